@@ -0,0 +1,68 @@
+// Package apierror defines the JSON error envelope returned by the HTTP API.
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Codes for failures that are not tied to a volume operation. Volume
+// operation codes are defined in the volume package.
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeServiceUnavailable = "service_unavailable"
+	CodeInternal           = "internal_error"
+)
+
+// Error is the body of a failed response.
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ErrorResponse is the envelope every error is returned in:
+//
+//	{"error": {"code": "volume_not_found", "message": "..."}}
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
+
+// Write sends the error envelope with the given status.
+func Write(w http.ResponseWriter, statusCode int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: Error{Code: code, Message: message}})
+}
+
+// CodeForStatus returns the generic code for a status, used when a failure
+// has no more specific code.
+func CodeForStatus(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusServiceUnavailable:
+		return CodeServiceUnavailable
+	default:
+		return CodeInternal
+	}
+}
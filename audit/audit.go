@@ -0,0 +1,299 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	OutcomeSuccess  = "success"
+	OutcomeAccepted = "accepted"
+	OutcomeFailure  = "failure"
+)
+
+// PrincipalDocker is recorded for calls the Docker daemon makes through the
+// volume plugin socket.
+const PrincipalDocker = "docker"
+
+// Entry is one audited operation. Hash covers every other field and the
+// previous entry's hash, so editing or removing an entry breaks the chain.
+// Status is the HTTP status, and is zero for operations that did not come
+// through the API, such as finished jobs, autogrow and the Docker plugin.
+// PeerUID is the UID of the caller of a request made over the unix socket.
+type Entry struct {
+	Seq        int64             `json:"seq"`
+	Time       time.Time         `json:"time"`
+	Principal  string            `json:"principal,omitempty"`
+	TokenID    string            `json:"token_id,omitempty"`
+	PeerUID    *uint32           `json:"peer_uid,omitempty"`
+	Operation  string            `json:"operation"`
+	Volume     string            `json:"volume,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	Outcome    string            `json:"outcome"`
+	Status     int               `json:"status,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	ErrorCode  string            `json:"error_code,omitempty"`
+	DurationMs int64             `json:"duration_ms"`
+	PrevHash   string            `json:"prev_hash"`
+	Hash       string            `json:"hash"`
+}
+
+// Filter selects entries by volume and time range. Zero values match
+// everything.
+type Filter struct {
+	Volume string
+	Since  time.Time
+	Until  time.Time
+}
+
+func (f Filter) matches(entry Entry) bool {
+	if f.Volume != "" && entry.Volume != f.Volume {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && entry.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// ChainError reports the first entry whose hash does not match its content
+// or predecessor.
+type ChainError struct {
+	Seq    int64
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit chain broken at entry %d: %s", e.Seq, e.Reason)
+}
+
+// Log is an append-only JSON-lines audit log.
+type Log struct {
+	path string
+
+	mu       sync.Mutex
+	seq      int64
+	lastHash string
+	// partial is set when the file ends in an incomplete line, so the next
+	// entry starts on a line of its own.
+	partial bool
+}
+
+// Open opens the log at path, creating its directory, and resumes the chain
+// from the last valid entry. Lines that are not valid entries, such as one
+// cut short by a crash, are skipped with a warning; Verify still reports
+// them.
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %v", err)
+	}
+
+	l := &Log{path: path}
+	err := l.scan(func(entry Entry) error {
+		l.seq = entry.Seq
+		l.lastHash = entry.Hash
+		return nil
+	}, func(chainErr *ChainError) error {
+		log.Printf("audit warning: skipping damaged entry in %s: %v", path, chainErr)
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	partial, err := endsInPartialLine(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	l.partial = partial
+	return l, nil
+}
+
+func endsInPartialLine(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return false, err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return false, err
+	}
+	return last[0] != '\n', nil
+}
+
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Append assigns the entry its sequence number and hash and writes it.
+func (l *Log) Append(entry Entry) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Seq = l.seq + 1
+	entry.Time = entry.Time.UTC()
+	entry.PrevHash = l.lastHash
+	hash, err := hashEntry(entry)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.Hash = hash
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return Entry{}, err
+	}
+	line = append(line, '\n')
+	if l.partial {
+		line = append([]byte{'\n'}, line...)
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer file.Close()
+	if _, err := file.Write(line); err != nil {
+		return Entry{}, err
+	}
+	if err := file.Sync(); err != nil {
+		return Entry{}, err
+	}
+
+	l.partial = false
+	l.seq = entry.Seq
+	l.lastHash = entry.Hash
+	return entry, nil
+}
+
+// Query returns the entries that match filter, oldest first.
+func (l *Log) Query(filter Filter) ([]Entry, error) {
+	return Query(l.path, filter)
+}
+
+// Query reads the log at path without opening it for writing. Damaged
+// lines are skipped.
+func Query(path string, filter Filter) ([]Entry, error) {
+	entries := []Entry{}
+	err := (&Log{path: path}).scan(func(entry Entry) error {
+		if filter.matches(entry) {
+			entries = append(entries, entry)
+		}
+		return nil
+	}, func(*ChainError) error {
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Verify walks the whole chain of the log at path and returns the number of
+// entries checked, or a *ChainError for the first tampered entry.
+func Verify(path string) (int64, error) {
+	var count int64
+	prevHash := ""
+	err := (&Log{path: path}).scan(func(entry Entry) error {
+		if entry.Seq != count+1 {
+			return &ChainError{Seq: entry.Seq, Reason: fmt.Sprintf("expected sequence %d", count+1)}
+		}
+		if entry.PrevHash != prevHash {
+			return &ChainError{Seq: entry.Seq, Reason: "previous hash does not match"}
+		}
+		hash, err := hashEntry(entry)
+		if err != nil {
+			return err
+		}
+		if hash != entry.Hash {
+			return &ChainError{Seq: entry.Seq, Reason: "entry content does not match its hash"}
+		}
+		count++
+		prevHash = entry.Hash
+		return nil
+	}, func(chainErr *ChainError) error {
+		return chainErr
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return count, err
+	}
+	return count, nil
+}
+
+func IsChainError(err error) bool {
+	var chainErr *ChainError
+	return errors.As(err, &chainErr)
+}
+
+// scan calls fn for each entry in order and onDamaged for each line that is
+// not a valid entry. Either stops the scan by returning an error.
+func (l *Log) scan(fn func(Entry) error, onDamaged func(*ChainError) error) error {
+	file, err := os.Open(l.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			if err := onDamaged(&ChainError{Seq: int64(line), Reason: fmt.Sprintf("line %d is not a valid entry: %v", line, err)}); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func hashEntry(entry Entry) (string, error) {
+	entry.Hash = ""
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(entry.PrevHash+"\n"), content...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ParseTime accepts an RFC 3339 timestamp or a duration such as "24h",
+// which is taken as that long before now.
+func ParseTime(raw string, now time.Time) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(raw); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time '%s': expected RFC 3339 or a duration such as 24h", raw)
+}
@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"hubfly-storage/jobs"
+)
+
+// A crash can leave the last line half written. The server must still
+// start and keep appending; only Verify reports the damaged line.
+func TestOpenSkipsTruncatedTrailingLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := auditLog.Append(Entry{Time: time.Now(), Operation: "create", Volume: "data", Outcome: OutcomeSuccess})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"seq":2,"time":"2026-`)
+	file.Close()
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open with a truncated trailing line: %v", err)
+	}
+	second, err := reopened.Append(Entry{Time: time.Now(), Operation: "delete", Volume: "data", Outcome: OutcomeSuccess})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Seq != 2 || second.PrevHash != first.Hash {
+		t.Errorf("appended entry %d with prev_hash %q, want 2 chained to %q", second.Seq, second.PrevHash, first.Hash)
+	}
+
+	entries, err := Query(path, Filter{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Query returned %d entries, want 2", len(entries))
+	}
+
+	if _, err := Verify(path); !IsChainError(err) {
+		t.Errorf("Verify = %v, want the damaged line reported", err)
+	}
+}
+
+func TestAsyncJobIsRecordedWhenItFinishes(t *testing.T) {
+	dir := t.TempDir()
+	auditLog, err := Open(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := Record(auditLog, "resize", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/jobs/abc123")
+		w.WriteHeader(http.StatusAccepted)
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/resize-volume?async=true", strings.NewReader(`{"Name":"data"}`)))
+
+	started := time.Now().UTC()
+	finished := started.Add(1500 * time.Millisecond)
+	RecordJob(auditLog, jobs.Job{
+		ID:         "abc123",
+		Type:       "resize",
+		Volume:     "data",
+		State:      jobs.StateFailed,
+		Error:      "not enough space",
+		ErrorCode:  "insufficient_host_space",
+		StartedAt:  &started,
+		FinishedAt: &finished,
+	})
+
+	entries, err := auditLog.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want accepted and final", len(entries))
+	}
+	accepted, final := entries[0], entries[1]
+	if accepted.Outcome != OutcomeAccepted || accepted.Params["job_id"] != "abc123" {
+		t.Errorf("accepted entry = %+v", accepted)
+	}
+	if final.Outcome != OutcomeFailure || final.Params["job_id"] != "abc123" || final.ErrorCode != "insufficient_host_space" || final.DurationMs != 1500 {
+		t.Errorf("final entry = %+v", final)
+	}
+
+	content, err := ioutil.ReadFile(auditLog.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(strings.Split(string(content), "\n")[1], `"status"`) {
+		t.Errorf("job entry records an HTTP status: %s", content)
+	}
+	if _, err := Verify(auditLog.Path()); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+}
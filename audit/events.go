@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"hubfly-storage/jobs"
+	"hubfly-storage/volume"
+)
+
+// RecordJob appends the final outcome of an asynchronous job. The request
+// that queued it was recorded as accepted with the same job_id parameter.
+func RecordJob(auditLog *Log, job jobs.Job) {
+	entry := Entry{
+		Time:      time.Now(),
+		Principal: job.Principal,
+		TokenID:   job.TokenID,
+		Operation: job.Type,
+		Volume:    job.Volume,
+		Params:    map[string]string{"job_id": job.ID},
+		Outcome:   OutcomeSuccess,
+	}
+	if job.FinishedAt != nil {
+		entry.Time = *job.FinishedAt
+		if job.StartedAt != nil {
+			entry.DurationMs = job.FinishedAt.Sub(*job.StartedAt).Milliseconds()
+		}
+	}
+	if job.State == jobs.StateFailed {
+		entry.Outcome = OutcomeFailure
+		entry.Error = job.Error
+		entry.ErrorCode = job.ErrorCode
+	}
+	appendEntry(auditLog, entry)
+}
+
+// RecordAutogrow appends a resize attempted by the autogrow watcher.
+func RecordAutogrow(auditLog *Log, event volume.AutogrowEvent) {
+	entry := Entry{
+		Time:      event.Time,
+		Operation: "autogrow_resize",
+		Volume:    event.Volume,
+		Params: map[string]string{
+			"usage_percent":       strconv.FormatFloat(event.UsagePercent, 'f', 1, 64),
+			"threshold_percent":   strconv.FormatFloat(event.ThresholdPercent, 'f', 1, 64),
+			"previous_size_bytes": strconv.FormatInt(event.PreviousSizeBytes, 10),
+			"new_size_bytes":      strconv.FormatInt(event.NewSizeBytes, 10),
+		},
+		Outcome: OutcomeSuccess,
+	}
+	if event.Error != "" {
+		entry.Outcome = OutcomeFailure
+		entry.Error = event.Error
+		entry.ErrorCode = event.ErrorCode
+	}
+	appendEntry(auditLog, entry)
+}
+
+// RecordPlugin appends a Docker volume plugin call made by the Docker
+// daemon. opts are the driver options, redacted like request parameters.
+func RecordPlugin(auditLog *Log, operation, volumeName string, opts map[string]string, started time.Time, err error) {
+	entry := Entry{
+		Time:       started,
+		Principal:  PrincipalDocker,
+		Operation:  operation,
+		Volume:     volumeName,
+		Outcome:    OutcomeSuccess,
+		DurationMs: time.Since(started).Milliseconds(),
+	}
+	for key, value := range opts {
+		if entry.Params == nil {
+			entry.Params = map[string]string{}
+		}
+		entry.Params["opt."+key] = redact(key, value)
+	}
+	if err != nil {
+		entry.Outcome = OutcomeFailure
+		entry.Error = err.Error()
+		entry.ErrorCode = volume.ErrorCode(err)
+	}
+	appendEntry(auditLog, entry)
+}
+
+func appendEntry(auditLog *Log, entry Entry) {
+	if _, err := auditLog.Append(entry); err != nil {
+		log.Printf("audit warning: failed to record %s of %s: %v", entry.Operation, entry.Volume, err)
+	}
+}
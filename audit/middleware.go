@@ -0,0 +1,191 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"hubfly-storage/apierror"
+	"hubfly-storage/auth"
+	"hubfly-storage/server"
+)
+
+const (
+	redacted          = "[REDACTED]"
+	maxAuditBodyBytes = 1 << 20
+	maxErrorLength    = 512
+)
+
+// requestFields are the payload fields recorded from the volume, snapshot
+// and FileBrowser requests.
+type requestFields struct {
+	Name       string            `json:"Name"`
+	Driver     string            `json:"Driver"`
+	DriverOpts map[string]string `json:"DriverOpts"`
+	Labels     map[string]string `json:"Labels"`
+	Snapshot   string            `json:"Snapshot"`
+	Target     string            `json:"Target"`
+	LowerName  string            `json:"name"`
+}
+
+// Record wraps next so each call is appended to the audit log with the
+// principal from the request context, the volume and redacted parameters
+// from the JSON body, the response status and the duration. A queued job
+// is recorded as accepted with its job_id; RecordJob adds its outcome.
+func Record(auditLog *Log, operation string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		started := time.Now()
+		fields := peekFields(r)
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next(recorder, r)
+
+		entry := Entry{
+			Time:       started,
+			Operation:  operation,
+			Volume:     fields.Name,
+			Params:     params(r, fields),
+			Status:     recorder.status,
+			Outcome:    outcomeFor(recorder.status),
+			DurationMs: time.Since(started).Milliseconds(),
+		}
+		if entry.Volume == "" {
+			entry.Volume = fields.LowerName
+		}
+		if entry.Volume == "" {
+			entry.Volume = volumeFromPath(r.URL.Path)
+		}
+		if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+			entry.Principal = principal.Name
+			entry.TokenID = principal.TokenID
+		}
+		if uid, ok := server.PeerUID(r.Context()); ok {
+			entry.PeerUID = &uid
+		}
+		if recorder.status == http.StatusAccepted {
+			if jobID := strings.TrimPrefix(recorder.Header().Get("Location"), "/jobs/"); jobID != "" {
+				if entry.Params == nil {
+					entry.Params = map[string]string{}
+				}
+				entry.Params["job_id"] = jobID
+			}
+		}
+		if recorder.status >= http.StatusBadRequest {
+			var envelope apierror.ErrorResponse
+			if err := json.Unmarshal(recorder.body.Bytes(), &envelope); err == nil && envelope.Error.Code != "" {
+				entry.Error = envelope.Error.Message
+				entry.ErrorCode = envelope.Error.Code
+			} else {
+				entry.Error = strings.TrimSpace(recorder.body.String())
+			}
+		}
+
+		appendEntry(auditLog, entry)
+	}
+}
+
+// volumeFromPath returns the volume named in a v2 resource path such as
+// /v2/volumes/data or /v2/volumes/data:resize.
+func volumeFromPath(path string) string {
+	const prefix = "/v2/volumes/"
+	if !strings.HasPrefix(path, prefix) {
+		return ""
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(path, prefix), "/")
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+func outcomeFor(status int) string {
+	switch {
+	case status == http.StatusAccepted:
+		return OutcomeAccepted
+	case status >= 200 && status < 300:
+		return OutcomeSuccess
+	default:
+		return OutcomeFailure
+	}
+}
+
+func peekFields(r *http.Request) requestFields {
+	var fields requestFields
+	if r.Body == nil {
+		return fields
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxAuditBodyBytes))
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return fields
+	}
+	_ = json.Unmarshal(body, &fields)
+	return fields
+}
+
+func params(r *http.Request, fields requestFields) map[string]string {
+	params := map[string]string{}
+	for key, value := range fields.DriverOpts {
+		params["opt."+key] = redact(key, value)
+	}
+	for key, value := range fields.Labels {
+		params["label."+key] = value
+	}
+	if fields.Snapshot != "" {
+		params["snapshot"] = fields.Snapshot
+	}
+	if fields.Target != "" {
+		params["target"] = fields.Target
+	}
+	for key, values := range r.URL.Query() {
+		params["query."+key] = redact(key, strings.Join(values, ","))
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
+// redact hides values whose key suggests a secret, such as encryption_key.
+func redact(key, value string) string {
+	lower := strings.ToLower(key)
+	for _, marker := range []string{"key", "pass", "secret", "token"} {
+		if strings.Contains(lower, marker) {
+			return redacted
+		}
+	}
+	return value
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	if r.status >= http.StatusBadRequest && r.body.Len() < maxErrorLength {
+		remaining := maxErrorLength - r.body.Len()
+		if remaining > len(p) {
+			remaining = len(p)
+		}
+		r.body.Write(p[:remaining])
+	}
+	return r.ResponseWriter.Write(p)
+}
@@ -0,0 +1,437 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"hubfly-storage/apierror"
+)
+
+type Scope string
+
+const (
+	ScopeVolumesRead      Scope = "volumes:read"
+	ScopeVolumesWrite     Scope = "volumes:write"
+	ScopeVolumesDelete    Scope = "volumes:delete"
+	ScopeFileBrowserShare Scope = "filebrowser:share"
+)
+
+// AllScopes lists every scope a token can be granted.
+var AllScopes = []Scope{ScopeVolumesRead, ScopeVolumesWrite, ScopeVolumesDelete, ScopeFileBrowserShare}
+
+const tokenPrefix = "hfs_"
+
+var ErrInvalidToken = errors.New("invalid or revoked token")
+
+// Token is a stored API token. Only the SHA-256 hash of the secret is kept.
+type Token struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash"`
+	Scopes    []Scope    `json:"scopes"`
+	Selector  Selector   `json:"selector,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Principal is the authenticated caller of a request. A non-empty Selector
+// limits it to volumes whose labels match.
+type Principal struct {
+	Name     string
+	TokenID  string
+	Scopes   []Scope
+	Selector Selector
+}
+
+func (p *Principal) HasScope(scope Scope) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// Store keeps tokens in a JSON file. The file is re-read whenever it
+// changes on disk, so tokens issued or revoked from the CLI take effect in
+// a running daemon without a restart.
+type Store struct {
+	path string
+
+	mu       sync.Mutex
+	tokens   []Token
+	modTime  time.Time
+	exists   bool
+	required bool
+	// enforced is set once the token file has been seen; removing the
+	// file afterwards rejects every token rather than opening the API.
+	enforced bool
+	// loadErr is the result of the reload done by the last Enabled call.
+	loadErr error
+}
+
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+func (s *Store) Path() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.path
+}
+
+// SetPath switches the store to another token file, which is read on the
+// next use.
+func (s *Store) SetPath(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	s.tokens = nil
+	s.exists = false
+	s.modTime = time.Time{}
+}
+
+// SetRequired enforces authentication even while the token file is
+// missing, in which case every bearer token is rejected.
+func (s *Store) SetRequired(required bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.required = required
+}
+
+// Enabled reports whether authentication is enforced: when it is required,
+// or once the token file has existed while the process runs.
+func (s *Store) Enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.loadErr = s.reloadLocked()
+	if s.loadErr != nil {
+		log.Printf("failed to load token file %s: %v", s.path, s.loadErr)
+		return true
+	}
+	if s.exists {
+		s.enforced = true
+	}
+	return s.required || s.enforced
+}
+
+// Issue creates a token and returns its secret, which is shown only once.
+// A non-empty selector restricts the token to volumes with matching labels.
+func (s *Store) Issue(name string, scopes []Scope, selector Selector) (string, Token, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", Token{}, errors.New("token name is required")
+	}
+	if len(scopes) == 0 {
+		return "", Token{}, errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return "", Token{}, fmt.Errorf("unknown scope '%s'", scope)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		return "", Token{}, err
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return "", Token{}, err
+	}
+	secretPart, err := randomHex(32)
+	if err != nil {
+		return "", Token{}, err
+	}
+	secret := tokenPrefix + id + "_" + secretPart
+
+	token := Token{
+		ID:        id,
+		Name:      strings.TrimSpace(name),
+		Hash:      hashSecret(secret),
+		Scopes:    scopes,
+		Selector:  selector,
+		CreatedAt: time.Now().UTC(),
+	}
+	s.tokens = append(s.tokens, token)
+	if err := s.saveLocked(); err != nil {
+		return "", Token{}, err
+	}
+	return secret, token, nil
+}
+
+func (s *Store) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		return err
+	}
+
+	for i := range s.tokens {
+		if s.tokens[i].ID != id {
+			continue
+		}
+		if s.tokens[i].RevokedAt != nil {
+			return fmt.Errorf("token '%s' is already revoked", id)
+		}
+		now := time.Now().UTC()
+		s.tokens[i].RevokedAt = &now
+		return s.saveLocked()
+	}
+	return fmt.Errorf("token '%s' not found", id)
+}
+
+func (s *Store) List() ([]Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return append([]Token{}, s.tokens...), nil
+}
+
+// Authenticate resolves a bearer secret to the principal it was issued to.
+func (s *Store) Authenticate(secret string) (*Principal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return s.authenticateLocked(secret)
+}
+
+// authenticateLoaded is Authenticate against the tokens loaded by the
+// Enabled call of the same request, so the file is checked once per request.
+func (s *Store) authenticateLoaded(secret string) (*Principal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+	return s.authenticateLocked(secret)
+}
+
+func (s *Store) authenticateLocked(secret string) (*Principal, error) {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	parts := strings.SplitN(strings.TrimPrefix(secret, tokenPrefix), "_", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidToken
+	}
+
+	hash := hashSecret(secret)
+	for _, token := range s.tokens {
+		if token.ID != parts[0] || token.RevokedAt != nil {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) == 1 {
+			return &Principal{
+				Name:     token.Name,
+				TokenID:  token.ID,
+				Scopes:   append([]Scope{}, token.Scopes...),
+				Selector: token.Selector,
+			}, nil
+		}
+	}
+	return nil, ErrInvalidToken
+}
+
+func (s *Store) reloadLocked() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			s.tokens = nil
+			s.exists = false
+			s.modTime = time.Time{}
+			return nil
+		}
+		return err
+	}
+	if s.exists && info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var tokens []Token
+	if len(strings.TrimSpace(string(content))) > 0 {
+		if err := json.Unmarshal(content, &tokens); err != nil {
+			return fmt.Errorf("invalid token file: %v", err)
+		}
+	}
+
+	s.tokens = tokens
+	s.exists = true
+	s.enforced = true
+	s.modTime = info.ModTime()
+	return nil
+}
+
+func (s *Store) saveLocked() error {
+	if s.tokens == nil {
+		s.tokens = []Token{}
+	}
+	content, err := json.MarshalIndent(s.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.exists = true
+	s.enforced = true
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// Authenticator combines the ways a request can prove its identity:
+// bearer tokens from the token store and HMAC signatures.
+type Authenticator struct {
+	Tokens *Store
+	HMAC   *HMACVerifier
+}
+
+func NewAuthenticator(tokens *Store, verifier *HMACVerifier) *Authenticator {
+	return &Authenticator{Tokens: tokens, HMAC: verifier}
+}
+
+// Enabled reports whether any authentication scheme is configured.
+func (a *Authenticator) Enabled() bool {
+	return (a.Tokens != nil && a.Tokens.Enabled()) || a.HMAC.Enabled()
+}
+
+// Require wraps next so it only runs for callers holding scope. While no
+// authentication scheme is configured, requests pass through unauthenticated.
+func Require(authenticator *Authenticator, scope Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authenticator.Enabled() {
+			next(w, r)
+			return
+		}
+
+		var principal *Principal
+		if IsSigned(r) && authenticator.HMAC.Enabled() {
+			var err error
+			principal, err = authenticator.HMAC.Verify(r)
+			if err != nil {
+				writeError(w, "Request signature rejected: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+		} else {
+			header := r.Header.Get("Authorization")
+			if authenticator.Tokens == nil || !strings.HasPrefix(header, "Bearer ") {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="hubfly-storage"`)
+				writeError(w, "Missing bearer token or request signature", http.StatusUnauthorized)
+				return
+			}
+
+			var err error
+			principal, err = authenticator.Tokens.authenticateLoaded(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="hubfly-storage", error="invalid_token"`)
+				writeError(w, "Invalid bearer token", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		if !principal.HasScope(scope) {
+			writeError(w, fmt.Sprintf("Token '%s' lacks the %s scope", principal.Name, scope), http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+	}
+}
+
+func ParseScopes(raw string) ([]Scope, error) {
+	var scopes []Scope
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if part == "all" {
+			return append([]Scope{}, AllScopes...), nil
+		}
+		if !validScope(Scope(part)) {
+			return nil, fmt.Errorf("unknown scope '%s'", part)
+		}
+		scopes = append(scopes, Scope(part))
+	}
+	return scopes, nil
+}
+
+func validScope(scope Scope) bool {
+	for _, known := range AllScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, msg string, statusCode int) {
+	log.Println("❌ " + msg)
+	apierror.Write(w, statusCode, apierror.CodeForStatus(statusCode), msg)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
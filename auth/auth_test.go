@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreFailsClosed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+
+	store := NewStore(path)
+	if store.Enabled() {
+		t.Fatalf("store without a token file is enabled")
+	}
+	store.SetRequired(true)
+	if !store.Enabled() {
+		t.Fatalf("required store without a token file is disabled")
+	}
+	store.SetRequired(false)
+
+	secret, _, err := store.Issue("ci", []Scope{ScopeVolumesRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.Enabled() {
+		t.Fatalf("store with a token file is disabled")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if !store.Enabled() {
+		t.Errorf("removing the token file disabled authentication")
+	}
+	if _, err := store.Authenticate(secret); err == nil {
+		t.Errorf("token accepted after the token file was removed")
+	}
+}
+
+func TestRequireRejectsRevokedToken(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "tokens.json"))
+	secret, token, err := store.Issue("ci", []Scope{ScopeVolumesRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Require(NewAuthenticator(store, NewHMACVerifier("", DefaultReplayWindow)), ScopeVolumesRead, func(w http.ResponseWriter, r *http.Request) {})
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/volumes", nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("valid token: status %d, want 200", code)
+	}
+	if err := store.Revoke(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := serve(); code != http.StatusUnauthorized {
+		t.Errorf("revoked token: status %d, want 401", code)
+	}
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderTimestamp = "X-Hubfly-Timestamp"
+	HeaderNonce     = "X-Hubfly-Nonce"
+	HeaderSignature = "X-Hubfly-Signature"
+
+	// DefaultReplayWindow is how far a request timestamp may drift from the
+	// server clock. Nonces are remembered for twice this long.
+	DefaultReplayWindow = 5 * time.Minute
+
+	maxSignedBodyBytes = 10 << 20
+)
+
+// HMACPrincipalName is the principal name of requests authenticated by
+// signature. Signed callers hold every scope.
+const HMACPrincipalName = "hmac"
+
+var ErrInvalidSignature = errors.New("invalid request signature")
+
+// HMACVerifier checks requests signed with a shared secret. A request is
+// signed over its method, path and query, timestamp, nonce and the SHA-256
+// digest of its body; each nonce is accepted once within the replay window.
+type HMACVerifier struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	secret []byte
+	nonces map[string]time.Time
+}
+
+func NewHMACVerifier(secret string, window time.Duration) *HMACVerifier {
+	if window <= 0 {
+		window = DefaultReplayWindow
+	}
+	v := &HMACVerifier{window: window, now: time.Now, nonces: make(map[string]time.Time)}
+	v.SetSecret(secret)
+	return v
+}
+
+// SetSecret replaces the shared secret. An empty secret disables signing.
+func (v *HMACVerifier) SetSecret(secret string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.secret = []byte(strings.TrimSpace(secret))
+}
+
+func (v *HMACVerifier) Enabled() bool {
+	if v == nil {
+		return false
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.secret) > 0
+}
+
+// IsSigned reports whether the request carries signature headers.
+func IsSigned(r *http.Request) bool {
+	return r.Header.Get(HeaderSignature) != ""
+}
+
+// Verify checks the signature headers of r. The body is read to compute its
+// digest and replaced so handlers can still decode it.
+func (v *HMACVerifier) Verify(r *http.Request) (*Principal, error) {
+	v.mu.Lock()
+	secret := v.secret
+	v.mu.Unlock()
+	if len(secret) == 0 {
+		return nil, ErrInvalidSignature
+	}
+
+	timestamp := r.Header.Get(HeaderTimestamp)
+	nonce := r.Header.Get(HeaderNonce)
+	signature := r.Header.Get(HeaderSignature)
+	if timestamp == "" || nonce == "" || signature == "" {
+		return nil, fmt.Errorf("%w: %s, %s and %s headers are required", ErrInvalidSignature, HeaderTimestamp, HeaderNonce, HeaderSignature)
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed timestamp", ErrInvalidSignature)
+	}
+	now := v.now()
+	skew := now.Sub(time.Unix(unix, 0))
+	if skew < -v.window || skew > v.window {
+		return nil, fmt.Errorf("%w: timestamp outside the %s replay window", ErrInvalidSignature, v.window)
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := computeSignature(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	provided, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(provided, expected) {
+		return nil, ErrInvalidSignature
+	}
+
+	if !v.rememberNonce(nonce, now) {
+		return nil, fmt.Errorf("%w: nonce already used", ErrInvalidSignature)
+	}
+
+	return &Principal{Name: HMACPrincipalName, Scopes: append([]Scope{}, AllScopes...)}, nil
+}
+
+func (v *HMACVerifier) rememberNonce(nonce string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for seen, expires := range v.nonces {
+		if now.After(expires) {
+			delete(v.nonces, seen)
+		}
+	}
+	if _, ok := v.nonces[nonce]; ok {
+		return false
+	}
+	v.nonces[nonce] = now.Add(2 * v.window)
+	return true
+}
+
+// SignRequest adds signature headers to req using secret. The body, if any,
+// is read and replaced. It is meant for callers of the API.
+func SignRequest(req *http.Request, secret string) error {
+	body, err := readBody(req)
+	if err != nil {
+		return err
+	}
+	nonce, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	signature := computeSignature([]byte(secret), req.Method, req.URL.RequestURI(), timestamp, nonce, body)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, hex.EncodeToString(signature))
+	return nil
+}
+
+func computeSignature(secret []byte, method, requestURI, timestamp, nonce string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, strings.Join([]string{
+		strings.ToUpper(method),
+		requestURI,
+		timestamp,
+		nonce,
+		hex.EncodeToString(digest[:]),
+	}, "\n"))
+	return mac.Sum(nil)
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBodyBytes+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	if len(body) > maxSignedBodyBytes {
+		return nil, fmt.Errorf("request body exceeds %d bytes", maxSignedBodyBytes)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHMACVerifierVerify(t *testing.T) {
+	const secret = "shared-secret"
+	clock := time.Date(2026, 3, 8, 10, 0, 0, 0, time.UTC)
+	window := DefaultReplayWindow
+
+	type signed struct {
+		secret    string
+		timestamp time.Time
+		nonce     string
+		body      string
+	}
+	// newRequest signs a POST of s.body as a client would and then sends
+	// body, which differs from s.body when the body was tampered with.
+	newRequest := func(s signed, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/resize-volume?async=true", strings.NewReader(body))
+		timestamp := strconv.FormatInt(s.timestamp.Unix(), 10)
+		signature := computeSignature([]byte(s.secret), req.Method, req.URL.RequestURI(), timestamp, s.nonce, []byte(s.body))
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderNonce, s.nonce)
+		req.Header.Set(HeaderSignature, hex.EncodeToString(signature))
+		return req
+	}
+	valid := signed{secret: secret, timestamp: clock, nonce: "n1", body: `{"Name":"data"}`}
+
+	tests := []struct {
+		name string
+		// earlier requests are verified first, and must succeed.
+		earlier []signed
+		request signed
+		body    string
+		wantErr bool
+	}{
+		{name: "valid", request: valid, body: valid.body},
+		{name: "inside the window", request: signed{secret, clock.Add(-window + time.Second), "n1", valid.body}, body: valid.body},
+		{name: "ahead of the clock inside the window", request: signed{secret, clock.Add(window - time.Second), "n1", valid.body}, body: valid.body},
+		{name: "older than the window", request: signed{secret, clock.Add(-window - time.Second), "n1", valid.body}, body: valid.body, wantErr: true},
+		{name: "beyond the window ahead", request: signed{secret, clock.Add(window + time.Second), "n1", valid.body}, body: valid.body, wantErr: true},
+		{name: "nonce reused", earlier: []signed{valid}, request: signed{secret, clock, "n1", `{"Name":"other"}`}, body: `{"Name":"other"}`, wantErr: true},
+		{name: "fresh nonce", earlier: []signed{valid}, request: signed{secret, clock, "n2", valid.body}, body: valid.body},
+		{name: "body tampered", request: valid, body: `{"Name":"other"}`, wantErr: true},
+		{name: "wrong secret", request: signed{"other-secret", clock, "n1", valid.body}, body: valid.body, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier := NewHMACVerifier(secret, window)
+			verifier.now = func() time.Time { return clock }
+			for _, earlier := range tt.earlier {
+				if _, err := verifier.Verify(newRequest(earlier, earlier.body)); err != nil {
+					t.Fatalf("earlier request: %v", err)
+				}
+			}
+
+			principal, err := verifier.Verify(newRequest(tt.request, tt.body))
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidSignature) {
+					t.Errorf("Verify = %v, want ErrInvalidSignature", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+			if principal.Name != HMACPrincipalName || !principal.HasScope(ScopeVolumesDelete) {
+				t.Errorf("principal = %+v", principal)
+			}
+		})
+	}
+}
+
+// A nonce is only remembered for twice the window, after which its
+// timestamp is already too old to be replayed.
+func TestHMACVerifierForgetsExpiredNonces(t *testing.T) {
+	clock := time.Date(2026, 3, 8, 10, 0, 0, 0, time.UTC)
+	verifier := NewHMACVerifier("shared-secret", time.Minute)
+	if !verifier.rememberNonce("n1", clock) {
+		t.Fatal("first use of a nonce rejected")
+	}
+
+	clock = clock.Add(3 * time.Minute)
+	if !verifier.rememberNonce("n2", clock) {
+		t.Fatal("fresh nonce rejected")
+	}
+	if _, ok := verifier.nonces["n1"]; ok {
+		t.Errorf("expired nonce still remembered")
+	}
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Selector binds a principal to the volumes whose labels contain every
+// key/value pair in it. An empty selector matches every volume.
+type Selector map[string]string
+
+// ParseSelector parses "key=value[,key=value...]".
+func ParseSelector(raw string) (Selector, error) {
+	selector := Selector{}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid selector '%s': expected key=value", part)
+		}
+		selector[key] = strings.TrimSpace(kv[1])
+	}
+	if len(selector) == 0 {
+		return nil, nil
+	}
+	return selector, nil
+}
+
+func (s Selector) Matches(labels map[string]string) bool {
+	for key, value := range s {
+		if actual, ok := labels[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Selector) String() string {
+	parts := make([]string, 0, len(s))
+	for key, value := range s {
+		parts = append(parts, key+"="+value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// Restricted reports whether the principal is limited to a subset of
+// volumes.
+func (p *Principal) Restricted() bool {
+	return p != nil && len(p.Selector) > 0
+}
+
+// CanAccess reports whether the principal may operate on a volume with the
+// given labels.
+func (p *Principal) CanAccess(labels map[string]string) bool {
+	if !p.Restricted() {
+		return true
+	}
+	return p.Selector.Matches(labels)
+}
@@ -0,0 +1,339 @@
+// Package client is a Go client for the hubfly-storage HTTP API. It speaks
+// the /v2/volumes routes, signs or authenticates requests the same way the
+// server checks them, and returns API failures as *Error values carrying the
+// server's error code.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"hubfly-storage/apierror"
+	"hubfly-storage/auth"
+	"hubfly-storage/handlers"
+	"hubfly-storage/volume"
+)
+
+const (
+	DefaultRetries      = 2
+	DefaultRetryBackoff = 200 * time.Millisecond
+
+	// unixBaseURL is the placeholder base URL used with WithUnixSocket;
+	// the host is ignored by the socket dialer.
+	unixBaseURL = "http://unix"
+)
+
+// Client calls a hubfly-storage server. It is safe for concurrent use.
+type Client struct {
+	baseURL      *url.URL
+	httpClient   *http.Client
+	token        string
+	hmacSecret   string
+	retries      int
+	retryBackoff time.Duration
+}
+
+type Option func(*Client)
+
+// WithToken authenticates every request with a bearer token issued by
+// `hubfly-storage token issue`.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHMACSecret signs every request with the server's HUBFLY_HMAC_SECRET.
+func WithHMACSecret(secret string) Option {
+	return func(c *Client) {
+		c.hmacSecret = secret
+	}
+}
+
+// WithHTTPClient replaces the underlying HTTP client, for example to set
+// TLS client certificates or a timeout.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithUnixSocket connects to the server's --socket instead of a TCP
+// address. The base URL passed to New is ignored.
+func WithUnixSocket(path string) Option {
+	return func(c *Client) {
+		dialer := &net.Dialer{}
+		c.httpClient = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", path)
+				},
+			},
+		}
+		c.baseURL, _ = url.Parse(unixBaseURL)
+	}
+}
+
+// WithRetries sets how many times an idempotent call is retried after a
+// connection error or a 502, 503 or 504, waiting backoff before the first
+// retry and doubling it after each one. Calls that change state are never
+// retried.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.retryBackoff = backoff
+	}
+}
+
+// New returns a client for the server at baseURL, such as
+// http://localhost:10007.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	parsed, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %v", err)
+	}
+
+	c := &Client{
+		baseURL:      parsed,
+		httpClient:   http.DefaultClient,
+		retries:      DefaultRetries,
+		retryBackoff: DefaultRetryBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.baseURL.Scheme == "" || c.baseURL.Host == "" {
+		return nil, fmt.Errorf("invalid base URL '%s': scheme and host are required", baseURL)
+	}
+	return c, nil
+}
+
+// Error is a failed API response. Code is one of the stable codes listed
+// in the README, such as volume.CodeVolumeNotFound.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("hubfly-storage: %s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+func (e *Error) ErrorCode() string {
+	return e.Code
+}
+
+// HasCode reports whether err is an API error with the given code.
+func HasCode(err error, code string) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// IsNotFound reports whether err means the volume, snapshot or other
+// resource does not exist.
+func IsNotFound(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// CreateVolume creates payload.Name with its DriverOpts and Labels.
+func (c *Client) CreateVolume(ctx context.Context, payload handlers.DockerVolumePayload) (*handlers.VolumeResponse, error) {
+	var response handlers.VolumeResponse
+	if err := c.do(ctx, http.MethodPut, volumePath(payload.Name.String(), ""), payload, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// ResizeVolume grows or shrinks payload.Name to DriverOpts["size"]. Set
+// DriverOpts["shrink"] to "true" to shrink.
+func (c *Client) ResizeVolume(ctx context.Context, payload handlers.DockerVolumePayload) (*handlers.ResizeResponse, error) {
+	var response handlers.ResizeResponse
+	if err := c.do(ctx, http.MethodPost, volumePath(payload.Name.String(), "resize"), payload, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// DeleteVolume deletes a volume. A volume with snapshots is only deleted,
+// together with them, when withSnapshots is set.
+func (c *Client) DeleteVolume(ctx context.Context, name string, withSnapshots bool) (*handlers.VolumeResponse, error) {
+	path := volumePath(name, "")
+	if withSnapshots {
+		path += "?delete_snapshots=true"
+	}
+	var response handlers.VolumeResponse
+	if err := c.do(ctx, http.MethodDelete, path, nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// Stats returns the usage statistics and metadata of a volume.
+func (c *Client) Stats(ctx context.Context, name string) (*volume.VolumeStats, error) {
+	var stats volume.VolumeStats
+	if err := c.do(ctx, http.MethodGet, volumePath(name, ""), nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// List returns every volume the caller may see.
+func (c *Client) List(ctx context.Context) ([]*volume.VolumeStats, error) {
+	var volumes []*volume.VolumeStats
+	if err := c.do(ctx, http.MethodGet, handlers.V2VolumesPath, nil, &volumes); err != nil {
+		return nil, err
+	}
+	return volumes, nil
+}
+
+// ShareURL returns a temporary FileBrowser login URL scoped to a volume.
+func (c *Client) ShareURL(ctx context.Context, name string) (string, error) {
+	volumeName, err := volume.ParseVolumeName(name)
+	if err != nil {
+		return "", err
+	}
+	var response handlers.URLVolumeCreateResponse
+	if err := c.do(ctx, http.MethodPost, "/url-volume/create", handlers.URLVolumeCreateRequest{Name: volumeName}, &response); err != nil {
+		return "", err
+	}
+	return response.URL, nil
+}
+
+func (c *Client) Health(ctx context.Context) (*handlers.HealthResponse, error) {
+	var response handlers.HealthResponse
+	if err := c.do(ctx, http.MethodGet, "/health", nil, &response); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func volumePath(name, action string) string {
+	path := handlers.V2VolumesPath + "/" + url.PathEscape(name)
+	if action != "" {
+		path += ":" + action
+	}
+	return path
+}
+
+// do sends one call and decodes a successful response into out. GET calls
+// are retried; everything else is sent once.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+	}
+
+	attempts := 1
+	if method == http.MethodGet {
+		attempts += c.retries
+	}
+
+	backoff := c.retryBackoff
+	var lastErr error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		retry, err := c.send(ctx, method, path, payload, out)
+		if err == nil || !retry {
+			return err
+		}
+		lastErr = err
+	}
+	return lastErr
+}
+
+// send performs a single attempt and reports whether a failure is worth
+// retrying.
+func (c *Client) send(ctx context.Context, method, path string, payload []byte, out interface{}) (bool, error) {
+	target := *c.baseURL
+	if i := strings.Index(path, "?"); i >= 0 {
+		path, target.RawQuery = path[:i], path[i+1:]
+	}
+	target.Path = strings.TrimSuffix(target.Path, "/") + path
+
+	var reader io.Reader
+	if payload != nil {
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), reader)
+	if err != nil {
+		return false, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.hmacSecret != "" {
+		if err := auth.SignRequest(req, c.hmacSecret); err != nil {
+			return false, fmt.Errorf("failed to sign request: %v", err)
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return retryableStatus(resp.StatusCode), decodeError(resp.StatusCode, content)
+	}
+	if out != nil && len(content) > 0 {
+		if err := json.Unmarshal(content, out); err != nil {
+			return false, fmt.Errorf("failed to decode response: %v", err)
+		}
+	}
+	return false, nil
+}
+
+func retryableStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// decodeError reads the JSON error envelope, falling back to the raw body
+// for responses that did not come from hubfly-storage, such as a proxy's.
+func decodeError(statusCode int, content []byte) error {
+	var envelope apierror.ErrorResponse
+	if err := json.Unmarshal(content, &envelope); err == nil && envelope.Error.Code != "" {
+		return &Error{StatusCode: statusCode, Code: envelope.Error.Code, Message: envelope.Error.Message}
+	}
+	message := strings.TrimSpace(string(content))
+	if message == "" {
+		message = http.StatusText(statusCode)
+	}
+	return &Error{StatusCode: statusCode, Code: apierror.CodeForStatus(statusCode), Message: message}
+}
@@ -0,0 +1,253 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hubfly-storage/apierror"
+	"hubfly-storage/auth"
+	"hubfly-storage/handlers"
+	"hubfly-storage/volume"
+)
+
+// statusServer answers every request with the statuses in order, repeating
+// the last one, and counts the requests it received.
+func statusServer(t *testing.T, statuses ...int) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&requests, 1))
+		status := statuses[len(statuses)-1]
+		if n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		if status >= http.StatusBadRequest {
+			apierror.Write(w, status, apierror.CodeForStatus(status), http.StatusText(status))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(handlers.HealthResponse{Status: "healthy"})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestErrorDecoding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case handlers.V2VolumesPath + "/missing":
+			apierror.Write(w, http.StatusNotFound, volume.CodeVolumeNotFound, "volume 'missing' not found")
+		default:
+			// A proxy in front of the server answers without the envelope.
+			http.Error(w, "upstream refused", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL, WithRetries(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Stats(context.Background(), "missing")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Stats returned %T %v, want *Error", err, err)
+	}
+	if apiErr.StatusCode != http.StatusNotFound || apiErr.Code != volume.CodeVolumeNotFound || apiErr.Message != "volume 'missing' not found" {
+		t.Errorf("Stats error = %+v", apiErr)
+	}
+	if !HasCode(err, volume.CodeVolumeNotFound) || !IsNotFound(err) {
+		t.Errorf("HasCode/IsNotFound do not recognise %v", err)
+	}
+
+	_, err = c.Stats(context.Background(), "other")
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Stats returned %T %v, want *Error", err, err)
+	}
+	if apiErr.Code != apierror.CodeInvalidRequest || apiErr.Message != "upstream refused" {
+		t.Errorf("error without an envelope = %+v", apiErr)
+	}
+}
+
+func TestGetRetriesGatewayErrors(t *testing.T) {
+	for _, status := range []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout} {
+		server, requests := statusServer(t, status, status, http.StatusOK)
+		c, err := New(server.URL, WithRetries(2, 20*time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		if _, err := c.Health(context.Background()); err != nil {
+			t.Errorf("Health after two %d responses: %v", status, err)
+		}
+		if got := atomic.LoadInt32(requests); got != 3 {
+			t.Errorf("%d: %d requests, want 3", status, got)
+		}
+		// The waits double: 20ms, then 40ms.
+		if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+			t.Errorf("%d: retries took %s, want at least 60ms of backoff", status, elapsed)
+		}
+	}
+}
+
+func TestGetGivesUpAfterRetries(t *testing.T) {
+	server, requests := statusServer(t, http.StatusServiceUnavailable)
+	c, err := New(server.URL, WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Health(context.Background())
+	if !HasCode(err, apierror.CodeServiceUnavailable) {
+		t.Errorf("Health = %v, want the last 503", err)
+	}
+	if got := atomic.LoadInt32(requests); got != 3 {
+		t.Errorf("%d requests, want 3", got)
+	}
+}
+
+func TestGetDoesNotRetryOtherErrors(t *testing.T) {
+	server, requests := statusServer(t, http.StatusInternalServerError, http.StatusOK)
+	c, err := New(server.URL, WithRetries(2, time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Health(context.Background()); err == nil {
+		t.Errorf("Health succeeded after a 500")
+	}
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Errorf("%d requests, want 1", got)
+	}
+}
+
+func TestRetryBackoffStopsOnCancel(t *testing.T) {
+	server, requests := statusServer(t, http.StatusServiceUnavailable)
+	c, err := New(server.URL, WithRetries(2, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = c.Health(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Health = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Health waited %s after the context ended", elapsed)
+	}
+	if got := atomic.LoadInt32(requests); got != 1 {
+		t.Errorf("%d requests, want 1", got)
+	}
+}
+
+func TestStateChangesAreNotRetried(t *testing.T) {
+	calls := map[string]func(c *Client) error{
+		http.MethodPut: func(c *Client) error {
+			_, err := c.CreateVolume(context.Background(), handlers.DockerVolumePayload{Name: "data"})
+			return err
+		},
+		http.MethodPost: func(c *Client) error {
+			_, err := c.ResizeVolume(context.Background(), handlers.DockerVolumePayload{Name: "data", DriverOpts: map[string]string{"size": "2G"}})
+			return err
+		},
+		http.MethodDelete: func(c *Client) error {
+			_, err := c.DeleteVolume(context.Background(), "data", false)
+			return err
+		},
+	}
+	for method, call := range calls {
+		server, requests := statusServer(t, http.StatusServiceUnavailable, http.StatusOK)
+		c, err := New(server.URL, WithRetries(2, time.Millisecond))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := call(c); !HasCode(err, apierror.CodeServiceUnavailable) {
+			t.Errorf("%s = %v, want the 503", method, err)
+		}
+		if got := atomic.LoadInt32(requests); got != 1 {
+			t.Errorf("%s: %d requests, want 1", method, got)
+		}
+	}
+}
+
+func TestHMACSigningIsAccepted(t *testing.T) {
+	const secret = "shared-secret"
+	verifier := auth.NewHMACVerifier(secret, auth.DefaultReplayWindow)
+	authenticator := auth.NewAuthenticator(nil, verifier)
+
+	var received handlers.DockerVolumePayload
+	server := httptest.NewServer(auth.Require(authenticator, auth.ScopeVolumesWrite, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &received)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(handlers.VolumeResponse{Status: "success", Name: received.Name.String()})
+	}))
+	defer server.Close()
+
+	c, err := New(server.URL, WithHMACSecret(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := handlers.DockerVolumePayload{Name: "data", DriverOpts: map[string]string{"size": "1G"}}
+	if _, err := c.CreateVolume(context.Background(), payload); err != nil {
+		t.Fatalf("signed CreateVolume: %v", err)
+	}
+	if received.Name != "data" || received.DriverOpts["size"] != "1G" {
+		t.Errorf("handler received %+v after verification", received)
+	}
+	if _, err := c.Health(context.Background()); err != nil {
+		t.Errorf("signed request without a body: %v", err)
+	}
+
+	wrong, err := New(server.URL, WithHMACSecret("other-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wrong.CreateVolume(context.Background(), payload)
+	if !HasCode(err, apierror.CodeUnauthorized) {
+		t.Errorf("CreateVolume with the wrong secret = %v, want unauthorized", err)
+	}
+}
+
+func TestWithUnixSocket(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(handlers.HealthResponse{Status: "healthy", Service: fmt.Sprintf("%s %s", r.Method, r.URL.Path)})
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	c, err := New("", WithUnixSocket(socketPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	health, err := c.Health(context.Background())
+	if err != nil {
+		t.Fatalf("Health over %s: %v", socketPath, err)
+	}
+	if health.Service != "GET /health" {
+		t.Errorf("server saw %q, want GET /health", health.Service)
+	}
+}
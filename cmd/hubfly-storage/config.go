@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"hubfly-storage/config"
+
+	"github.com/joho/godotenv"
+)
+
+// addServeFlags registers the daemon flags, which override the config file
+// and the environment only when set. It returns the --config value.
+func addServeFlags(fs *flag.FlagSet) *string {
+	defaults := config.Default()
+	configPath := addConfigFlag(fs)
+	fs.String("listen", defaults.Listen, "TCP address for the HTTP API (empty to disable)")
+	fs.String("base-dir", defaults.BaseDir, "directory holding the volume images and service state")
+	fs.String("tokens-file", defaults.TokensFile, "path to the API token store; authentication is enforced once it exists")
+	fs.Bool("require-auth", true, "enforce authentication even while the token store does not exist; false leaves the API open until a token is issued")
+	fs.String("audit-log", "", "path to the append-only audit log (default <base-dir>/.audit/audit.log)")
+	fs.String("plugin-socket", defaults.PluginSocket, "unix socket for the Docker volume plugin API (empty to disable)")
+	fs.Duration("autogrow-interval", time.Minute, "how often volumes with an autogrow policy are checked")
+	fs.String("socket", "", "unix socket for the HTTP API, served alongside or instead of --listen")
+	fs.String("socket-owner", "", "owner of --socket as user[:group]")
+	fs.String("socket-mode", defaults.Socket.Mode, "permissions of --socket")
+	fs.String("socket-allow-uids", "", "comma-separated UIDs allowed to connect to --socket (empty allows any)")
+	fs.String("tls-cert", "", "PEM certificate for serving the API over TLS")
+	fs.String("tls-key", "", "PEM private key for --tls-cert")
+	fs.String("tls-client-ca", "", "PEM CA bundle; when set, clients must present a certificate signed by it")
+	fs.String("default-size", defaults.Volumes.DefaultSize, "size of volumes created without DriverOpts.size")
+	fs.String("default-optimization", defaults.Volumes.DefaultOptimization, "optimization of volumes created without DriverOpts.optimization")
+	fs.String("max-volume-size", "", "largest size a volume may be created with or resized to (empty for no limit)")
+	fs.String("filebrowser-url", defaults.FileBrowser.URL, "base URL of FileBrowser (env FILEBROWSER_URL)")
+	fs.String("filebrowser-admin-user", defaults.FileBrowser.AdminUser, "FileBrowser admin user (env FILEBROWSER_ADMIN_USER)")
+	fs.String("filebrowser-binary", "", "optional path to the FileBrowser binary")
+	return configPath
+}
+
+// addConfigFlag registers --config, which the daemon and the subcommands
+// that read its settings share.
+func addConfigFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", "", "TOML config file, with .env next to it (env "+config.PathEnv+"; default "+config.DefaultPath+" if present)")
+}
+
+// dotenv loads a .env file into the environment without replacing
+// variables the process was started with. It remembers which variables came
+// from the file, so loading it again picks up changed values and drops
+// removed ones.
+type dotenv struct {
+	path   string
+	loaded map[string]bool
+}
+
+func (d *dotenv) load() error {
+	values := map[string]string{}
+	if _, err := os.Stat(d.path); err == nil {
+		if values, err = godotenv.Read(d.path); err != nil {
+			return fmt.Errorf("failed to load %s: %v", d.path, err)
+		}
+	}
+
+	for key := range d.loaded {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+		}
+	}
+	loaded := map[string]bool{}
+	for key, value := range values {
+		if _, set := os.LookupEnv(key); set && !d.loaded[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		loaded[key] = true
+	}
+	d.loaded = loaded
+	return nil
+}
+
+// loadConfig reads .env, whose variables override the config file like any
+// other environment variable, and then the configuration.
+func loadConfig(env *dotenv, configPath string, fs *flag.FlagSet) (*config.Config, error) {
+	if err := env.load(); err != nil {
+		return nil, err
+	}
+	return config.Load(configPath, fs)
+}
+
+// warnLegacyPaths reports state from an earlier version that is still used
+// from the working directory because it was not moved or configured.
+func warnLegacyPaths(cfg *config.Config, envPath string) {
+	if cfg.LegacyBaseDir {
+		log.Printf("warning: using %s from an earlier version as base_dir; set base_dir to keep it there (see Migrating in the README)", cfg.BaseDir)
+	}
+	if defaultEnv := filepath.Join(config.DefaultDir, config.EnvFile); cfg.Path == "" && envPath != defaultEnv {
+		log.Printf("warning: using %s from an earlier version; move it to %s (see Migrating in the README)", envPath, defaultEnv)
+	}
+}
+
+func runConfigCommand(args []string) int {
+	if len(args) == 0 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: hubfly-storage config print [--config PATH] [daemon flags]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config print", flag.ContinueOnError)
+	configPath := addServeFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, err := loadConfig(&dotenv{path: config.EnvPath(*configPath)}, *configPath, fs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := cfg.Write(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to print configuration: %v\n", err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
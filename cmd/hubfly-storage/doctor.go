@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"hubfly-storage/client"
+	"hubfly-storage/config"
+)
+
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+type doctorCheck struct {
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// requiredTools are run by the volume package for every volume; optional
+// tools are only needed for encryption, frozen snapshots and shrinking.
+var (
+	requiredTools = []string{"sudo", "docker", "losetup", "mkfs.ext4", "mount", "umount", "fallocate", "resize2fs", "e2fsck", "df", "findmnt"}
+	optionalTools = []string{"cryptsetup", "fsfreeze", "lsblk"}
+)
+
+func runDoctorCommand(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	remote := addRemoteFlags(fs)
+	configPath := addConfigFlag(fs)
+	fs.String("base-dir", "", "volume base directory of the daemon on this host (default base_dir from the configuration)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cfg, err := loadConfig(&dotenv{path: config.EnvPath(*configPath)}, *configPath, fs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := remote.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	checks := checkDaemon(remote)
+	checks = append(checks, checkTools()...)
+	checks = append(checks, checkBaseDir(cfg.BaseDir))
+
+	failed := false
+	for _, check := range checks {
+		if check.Status == checkFail {
+			failed = true
+		}
+	}
+
+	if remote.output == "json" {
+		printJSON(checks)
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "CHECK\tSTATUS\tDETAIL")
+		for _, check := range checks {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Check, check.Status, check.Detail)
+		}
+		tw.Flush()
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// checkDaemon reaches the daemon, reports its FileBrowser status and
+// confirms the credentials can list volumes.
+func checkDaemon(remote *remoteFlags) []doctorCheck {
+	target := remote.addr
+	if remote.socket != "" {
+		target = remote.socket
+	}
+
+	c, err := remote.client()
+	if err != nil {
+		return []doctorCheck{{Check: "daemon", Status: checkFail, Detail: err.Error()}}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), remote.timeout)
+	defer cancel()
+
+	health, err := c.Health(ctx)
+	if err != nil {
+		return []doctorCheck{{Check: "daemon", Status: checkFail, Detail: fmt.Sprintf("%s: %v", target, err)}}
+	}
+	checks := []doctorCheck{{Check: "daemon", Status: checkOK, Detail: fmt.Sprintf("%s %s at %s", health.Service, health.Version, target)}}
+
+	switch {
+	case health.FileBrowser == nil:
+		checks = append(checks, doctorCheck{Check: "filebrowser", Status: checkWarn, Detail: "not reported by the daemon"})
+	case health.FileBrowser.Running:
+		checks = append(checks, doctorCheck{Check: "filebrowser", Status: checkOK, Detail: strings.TrimSpace(health.FileBrowser.Version + " at " + health.FileBrowser.URL)})
+	default:
+		checks = append(checks, doctorCheck{Check: "filebrowser", Status: checkWarn, Detail: "not running at " + health.FileBrowser.URL + "; share links will fail"})
+	}
+
+	volumes, err := c.List(ctx)
+	if err != nil {
+		detail := err.Error()
+		var apiErr *client.Error
+		if errors.As(err, &apiErr) {
+			detail = apiErr.Message
+		}
+		checks = append(checks, doctorCheck{Check: "credentials", Status: checkFail, Detail: detail})
+	} else {
+		checks = append(checks, doctorCheck{Check: "credentials", Status: checkOK, Detail: fmt.Sprintf("%d volumes visible", len(volumes))})
+	}
+	return checks
+}
+
+func checkTools() []doctorCheck {
+	var missingRequired, missingOptional []string
+	for _, tool := range requiredTools {
+		if !toolAvailable(tool) {
+			missingRequired = append(missingRequired, tool)
+		}
+	}
+	for _, tool := range optionalTools {
+		if !toolAvailable(tool) {
+			missingOptional = append(missingOptional, tool)
+		}
+	}
+
+	checks := []doctorCheck{}
+	if len(missingRequired) > 0 {
+		checks = append(checks, doctorCheck{Check: "tools", Status: checkFail, Detail: "missing " + strings.Join(missingRequired, ", ")})
+	} else {
+		checks = append(checks, doctorCheck{Check: "tools", Status: checkOK, Detail: strings.Join(requiredTools, ", ")})
+	}
+	if len(missingOptional) > 0 {
+		checks = append(checks, doctorCheck{Check: "optional tools", Status: checkWarn, Detail: "missing " + strings.Join(missingOptional, ", ") + "; encryption, frozen snapshots or shrinking may fail"})
+	}
+	return checks
+}
+
+// toolAvailable looks a tool up in PATH and in the sbin directories, which
+// are often missing from an unprivileged PATH but are searched by sudo.
+func toolAvailable(tool string) bool {
+	if _, err := exec.LookPath(tool); err == nil {
+		return true
+	}
+	for _, dir := range []string{"/sbin", "/usr/sbin", "/usr/local/sbin"} {
+		if info, err := os.Stat(filepath.Join(dir, tool)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+func checkBaseDir(baseDir string) doctorCheck {
+	check := doctorCheck{Check: "base directory"}
+	info, err := os.Stat(baseDir)
+	if err != nil {
+		check.Status = checkFail
+		check.Detail = err.Error()
+		return check
+	}
+	if !info.IsDir() {
+		check.Status = checkFail
+		check.Detail = baseDir + " is not a directory"
+		return check
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(baseDir, &stat); err != nil {
+		check.Status = checkWarn
+		check.Detail = fmt.Sprintf("failed to read free space: %v", err)
+		return check
+	}
+	available := int64(stat.Bavail) * int64(stat.Bsize)
+	total := int64(stat.Blocks) * int64(stat.Bsize)
+
+	check.Status = checkOK
+	check.Detail = fmt.Sprintf("%s: %s free of %s", baseDir, formatBytes(available), formatBytes(total))
+	if total > 0 && available*10 < total {
+		check.Status = checkWarn
+		check.Detail += "; less than 10% free"
+	}
+	return check
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for value := n / unit; value >= unit; value /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -1,16 +1,24 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 
+	"hubfly-storage/audit"
+	"hubfly-storage/auth"
+	"hubfly-storage/config"
 	"hubfly-storage/filebrowser"
 	"hubfly-storage/handlers"
-
-	"github.com/joho/godotenv"
+	"hubfly-storage/jobs"
+	"hubfly-storage/plugin"
+	"hubfly-storage/server"
+	"hubfly-storage/volume"
 )
 
 var version = "dev"
@@ -20,51 +28,206 @@ func main() {
 		fmt.Println(version)
 		return
 	}
+	if len(os.Args) > 1 && os.Args[1] == "openapi" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(handlers.OpenAPIDocument(version)); err != nil {
+			log.Fatalf("Failed to encode OpenAPI document: %v", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		os.Exit(runTokenCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "audit" {
+		os.Exit(runAuditCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "volume" {
+		os.Exit(runVolumeCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "share" {
+		os.Exit(runShareCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctorCommand(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		os.Exit(runConfigCommand(os.Args[2:]))
+	}
 
-	fileBrowserBinaryPath := flag.String("filebrowser-binary", "", "optional path to the FileBrowser binary")
+	configPath := addServeFlags(flag.CommandLine)
 	flag.Parse()
 
-	envPath := ".env"
+	envPath := config.EnvPath(*configPath)
 	if err := filebrowser.EnsureEnvFile(envPath); err != nil {
 		log.Printf("Failed ensuring .env file: %v", err)
 	}
-
-	err := godotenv.Load(envPath)
+	env := &dotenv{path: envPath}
+	cfg, err := loadConfig(env, *configPath, flag.CommandLine)
 	if err != nil {
-		log.Println("No .env file found")
+		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if cfg.Path != "" {
+		log.Printf("Loaded configuration from %s", cfg.Path)
+	}
+	warnLegacyPaths(cfg, envPath)
+
+	autogrowInterval, _ := cfg.Interval()
+	socketOptions, _ := cfg.SocketOptions()
+	tlsFiles := cfg.TLSFiles()
+
+	tokenStore := auth.NewStore(cfg.TokensFile)
+	hmacVerifier := auth.NewHMACVerifier(os.Getenv("HUBFLY_HMAC_SECRET"), auth.DefaultReplayWindow)
+	configReloader := &reloader{
+		env:        env,
+		configPath: *configPath,
+		flags:      flag.CommandLine,
+		tokens:     tokenStore,
+		hmac:       hmacVerifier,
+	}
+	configReloader.apply(cfg)
+	go configReloader.watch()
 
-	resolvedFileBrowserBinaryPath := filebrowser.ResolveBinaryPath(*fileBrowserBinaryPath)
+	fileBrowserBinaryPath := cfg.FileBrowser.Binary
+	resolvedFileBrowserBinaryPath := filebrowser.ResolveBinaryPath(fileBrowserBinaryPath)
 	if resolvedFileBrowserBinaryPath == "" {
 		log.Printf("FileBrowser binary unavailable; checked optional path and default fallback")
 	} else {
 		log.Printf("Using FileBrowser binary at %s", resolvedFileBrowserBinaryPath)
 	}
 
-	go filebrowser.BootstrapAdminPassword(envPath, *fileBrowserBinaryPath)
+	go filebrowser.BootstrapAdminPassword(envPath, fileBrowserBinaryPath)
 
-	baseDir := "./docker/volumes"
+	baseDir := cfg.BaseDir
 	if err := os.MkdirAll(baseDir, 0755); err != nil {
 		log.Fatalf("Failed to create base directory: %v", err)
 	}
 
-	http.HandleFunc("/create-volume", handlers.CreateVolumeHandler(baseDir))
-	http.HandleFunc("/delete-volume", handlers.DeleteVolumeHandler(baseDir))
-	http.HandleFunc("/resize-volume", handlers.ResizeVolumeHandler(baseDir))
-	http.HandleFunc("/health", handlers.HealthCheckHandler(version, func() handlers.FileBrowserHealth {
-		fbHealth := filebrowser.Probe(os.Getenv("FILEBROWSER_URL"), *fileBrowserBinaryPath)
-		return handlers.FileBrowserHealth{
-			Running: fbHealth.Running,
-			Version: fbHealth.Version,
-			URL:     fbHealth.URL,
+	auditLog, err := audit.Open(cfg.AuditLog)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+
+	reattachResults, err := volume.ReattachVolumes(baseDir)
+	if err != nil {
+		log.Printf("Failed to reattach volumes: %v", err)
+	}
+	for _, result := range reattachResults {
+		if result.Error != "" {
+			log.Printf("Reattach %s: %s: %s", result.Name, result.Outcome, result.Error)
+			continue
+		}
+		log.Printf("Reattach %s: %s", result.Name, result.Outcome)
+	}
+
+	autogrowWatcher := volume.NewAutogrowWatcher(baseDir, autogrowInterval, func(event volume.AutogrowEvent) {
+		if event.Error == "" {
+			log.Printf("Autogrow grew volume %s from %d to %d bytes at %.1f%% usage", event.Volume, event.PreviousSizeBytes, event.NewSizeBytes, event.UsagePercent)
 		}
-	}))
-	http.HandleFunc("/volume-stats", handlers.GetVolumeStatsHandler(baseDir))
-	http.HandleFunc("/dev/volumes", handlers.GetVolumesHandler(baseDir))
-	http.HandleFunc("/url-volume/create", handlers.URLVolumeCreateHandler(baseDir, resolvedFileBrowserBinaryPath))
+		audit.RecordAutogrow(auditLog, event)
+	})
+	go autogrowWatcher.Run(context.Background())
+
+	if cfg.PluginSocket != "" {
+		driver, err := plugin.NewDriver(baseDir, auditLog)
+		if err != nil {
+			log.Fatalf("Failed to start Docker volume plugin: %v", err)
+		}
+		go func() {
+			log.Printf("Serving Docker volume plugin API on %s", cfg.PluginSocket)
+			if err := plugin.Serve(cfg.PluginSocket, driver.Handler()); err != nil {
+				log.Printf("Docker volume plugin API stopped: %v", err)
+			}
+		}()
+	}
 
-	log.Println("🚀 Server running on port 10007...")
-	if err := http.ListenAndServe(":10007", nil); err != nil {
-		log.Fatalf("Server error: %v", err)
+	jobManager, err := jobs.NewManager(filepath.Join(baseDir, ".jobs"), 4, func(job jobs.Job) {
+		audit.RecordJob(auditLog, job)
+	})
+	if err != nil {
+		log.Fatalf("Failed to start job manager: %v", err)
+	}
+
+	authenticator := auth.NewAuthenticator(tokenStore, hmacVerifier)
+	if tokenStore.Enabled() {
+		log.Printf("API token authentication enabled using %s", cfg.TokensFile)
+		if _, err := os.Stat(cfg.TokensFile); os.IsNotExist(err) {
+			log.Printf("warning: %s does not exist, so every bearer token is rejected; issue one with `hubfly-storage token issue`", cfg.TokensFile)
+		}
+	}
+	if hmacVerifier.Enabled() {
+		log.Printf("HMAC request signing enabled")
 	}
+	if !authenticator.Enabled() {
+		log.Printf("warning: API authentication disabled by require_auth = false; issue a token with `hubfly-storage token issue` or set HUBFLY_HMAC_SECRET to enable it")
+	}
+	routes := handlers.Routes(handlers.Deps{
+		BaseDir:           baseDir,
+		Version:           version,
+		Jobs:              jobManager,
+		AuditLog:          auditLog,
+		Autogrow:          autogrowWatcher,
+		FileBrowserBinary: resolvedFileBrowserBinaryPath,
+		FileBrowserHealth: func() handlers.FileBrowserHealth {
+			fbHealth := filebrowser.Probe(filebrowser.CurrentSettings().URL, fileBrowserBinaryPath)
+			return handlers.FileBrowserHealth{
+				Running: fbHealth.Running,
+				Version: fbHealth.Version,
+				URL:     fbHealth.URL,
+			}
+		},
+	})
+	// Authentication runs first, so the audit entry carries the principal.
+	mux := handlers.NewMux(routes, func(route handlers.Route) http.HandlerFunc {
+		handler := route.Handler
+		if route.Audit != "" {
+			handler = audit.Record(auditLog, route.Audit, handler)
+		}
+		if route.Scope != "" {
+			handler = auth.Require(authenticator, auth.Scope(route.Scope), handler)
+		}
+		return handler
+	})
+
+	serveErrors := make(chan error, 2)
+	if cfg.Socket.Path != "" {
+		listener, err := server.ListenUnix(cfg.Socket.Path, socketOptions)
+		if err != nil {
+			log.Fatalf("Failed to serve on %s: %v", cfg.Socket.Path, err)
+		}
+		socketServer := &http.Server{Handler: mux, ConnContext: server.ConnContext}
+		log.Printf("🚀 Server running on unix socket %s...", cfg.Socket.Path)
+		go func() {
+			serveErrors <- socketServer.Serve(listener)
+		}()
+	}
+
+	if cfg.Listen != "" {
+		httpServer := &http.Server{Addr: cfg.Listen, Handler: mux}
+		if !tlsFiles.Enabled() {
+			log.Printf("🚀 Server running on %s...", cfg.Listen)
+			go func() {
+				serveErrors <- httpServer.ListenAndServe()
+			}()
+		} else {
+			certReloader, err := server.NewCertReloader(tlsFiles)
+			if err != nil {
+				log.Fatalf("Failed to load TLS certificate: %v", err)
+			}
+			httpServer.TLSConfig = certReloader.TLSConfig()
+			if tlsFiles.ClientCAFile != "" {
+				log.Printf("🚀 Server running on %s with mutual TLS...", cfg.Listen)
+			} else {
+				log.Printf("🚀 Server running on %s with TLS...", cfg.Listen)
+			}
+			go func() {
+				serveErrors <- httpServer.ListenAndServeTLS("", "")
+			}()
+		}
+	}
+
+	log.Fatalf("Server error: %v", <-serveErrors)
 }
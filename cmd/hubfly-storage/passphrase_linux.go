@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func getTermios(fd uintptr) (*syscall.Termios, bool) {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return &termios, errno == 0
+}
+
+func setTermios(fd uintptr, termios *syscall.Termios) bool {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCSETS, uintptr(unsafe.Pointer(termios)))
+	return errno == 0
+}
+
+func isTerminal(f *os.File) bool {
+	_, ok := getTermios(f.Fd())
+	return ok
+}
+
+// readHiddenLine reads a line from the terminal with echo turned off.
+func readHiddenLine(terminal *os.File) (string, error) {
+	fd := terminal.Fd()
+	saved, ok := getTermios(fd)
+	if !ok {
+		return readLine(terminal)
+	}
+	hidden := *saved
+	hidden.Lflag &^= syscall.ECHO
+	if setTermios(fd, &hidden) {
+		defer setTermios(fd, saved)
+	}
+	return readLine(terminal)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os"
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// readHiddenLine reads a line from the terminal. Echo is only turned off on
+// linux.
+func readHiddenLine(terminal *os.File) (string, error) {
+	return readLine(terminal)
+}
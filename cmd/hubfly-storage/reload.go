@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"hubfly-storage/auth"
+	"hubfly-storage/config"
+	"hubfly-storage/filebrowser"
+	"hubfly-storage/volume"
+)
+
+// reloader re-reads .env and the config file on SIGHUP. A new configuration
+// is validated as a whole before any of it is applied; volume limits, the
+// token file and require_auth, the HMAC secret and the FileBrowser settings
+// are then swapped in place, and every other changed setting is reported as
+// needing a restart.
+type reloader struct {
+	env        *dotenv
+	configPath string
+	flags      *flag.FlagSet
+	tokens     *auth.Store
+	hmac       *auth.HMACVerifier
+
+	started *config.Config
+	current *config.Config
+}
+
+// reloadedEnv are the variables outside the config file that take effect on
+// a reload. Their values are never logged.
+var reloadedEnv = []string{"HUBFLY_HMAC_SECRET", "FILEBROWSER_ADMIN_PASS", "VOLUME_ENCRYPTION_KEY"}
+
+// apply swaps in the settings that can change while the server runs.
+func (r *reloader) apply(cfg *config.Config) {
+	limits, _ := cfg.Limits()
+	volume.SetLimits(limits)
+	filebrowser.SetSettings(filebrowser.Settings{
+		URL:       cfg.FileBrowser.URL,
+		AdminUser: cfg.FileBrowser.AdminUser,
+		AdminPass: os.Getenv("FILEBROWSER_ADMIN_PASS"),
+	})
+	r.tokens.SetPath(cfg.TokensFile)
+	requireAuth, _ := cfg.AuthRequired()
+	r.tokens.SetRequired(requireAuth)
+	r.hmac.SetSecret(os.Getenv("HUBFLY_HMAC_SECRET"))
+	if r.started == nil {
+		r.started = cfg
+	}
+	r.current = cfg
+}
+
+func (r *reloader) reload() {
+	previousEnv := map[string]string{}
+	for _, key := range reloadedEnv {
+		if value, ok := os.LookupEnv(key); ok {
+			previousEnv[key] = value
+		}
+	}
+
+	cfg, err := loadConfig(r.env, r.configPath, r.flags)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err == nil {
+		err = r.checkTokensFile(cfg)
+	}
+	if err != nil {
+		// VOLUME_ENCRYPTION_KEY is read from the environment on use, so
+		// the new .env values must not outlive a rejected reload.
+		for _, key := range reloadedEnv {
+			if value, ok := previousEnv[key]; ok {
+				os.Setenv(key, value)
+			} else {
+				os.Unsetenv(key)
+			}
+		}
+		log.Printf("Reload failed; keeping the previous configuration: %v", err)
+		return
+	}
+
+	// Settings that need a restart are compared with the running ones, so
+	// they are reported again until the restart happens.
+	reloaded, _ := cfg.Changes(r.current)
+	_, restart := cfg.Changes(r.started)
+	for _, key := range reloadedEnv {
+		if os.Getenv(key) != previousEnv[key] {
+			reloaded = append(reloaded, key)
+		}
+	}
+	r.apply(cfg)
+
+	if len(reloaded) == 0 {
+		log.Printf("Reloaded configuration; nothing changed")
+	} else {
+		log.Printf("Reloaded configuration; applied %s", strings.Join(reloaded, ", "))
+	}
+	if len(restart) > 0 {
+		log.Printf("warning: restart hubfly-storage to apply %s", strings.Join(restart, ", "))
+	}
+}
+
+// checkTokensFile refuses to move an enforcing token store to a file that
+// does not exist, which would reject every token, most likely because of a
+// typo in the new path.
+func (r *reloader) checkTokensFile(cfg *config.Config) error {
+	if cfg.TokensFile == r.current.TokensFile || !r.tokens.Enabled() {
+		return nil
+	}
+	if _, err := os.Stat(cfg.TokensFile); err != nil {
+		return fmt.Errorf("tokens_file %s is not readable while authentication is enabled: %v", cfg.TokensFile, err)
+	}
+	return nil
+}
+
+// watch reloads on every SIGHUP until the process exits.
+func (r *reloader) watch() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		log.Printf("Received SIGHUP; reloading configuration")
+		r.reload()
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"hubfly-storage/client"
+)
+
+const defaultAddr = "http://localhost:10007"
+
+// remoteFlags are the connection and output flags shared by the subcommands
+// that talk to a running daemon. Defaults come from HUBFLY_* environment
+// variables so they can be set once per shell. Credentials are never taken
+// from a flag, so they do not appear in the process list or shell history.
+type remoteFlags struct {
+	addr            string
+	socket          string
+	tokenStdin      bool
+	hmacSecretStdin bool
+	tlsCA           string
+	tlsCert         string
+	tlsKey          string
+	timeout         time.Duration
+	output          string
+}
+
+func addRemoteFlags(fs *flag.FlagSet) *remoteFlags {
+	f := &remoteFlags{}
+	fs.StringVar(&f.addr, "addr", envOr("HUBFLY_ADDR", defaultAddr), "base URL of the daemon (env HUBFLY_ADDR)")
+	fs.StringVar(&f.socket, "socket", os.Getenv("HUBFLY_SOCKET"), "unix socket of the daemon, used instead of --addr (env HUBFLY_SOCKET)")
+	fs.BoolVar(&f.tokenStdin, "token-stdin", false, "read the bearer token from stdin, prompting on a terminal (default env HUBFLY_TOKEN)")
+	fs.BoolVar(&f.hmacSecretStdin, "hmac-secret-stdin", false, "read a secret from stdin and sign requests with it instead of sending a token (default env HUBFLY_HMAC_SECRET)")
+	fs.StringVar(&f.tlsCA, "tls-ca", "", "PEM CA bundle to verify the daemon's certificate")
+	fs.StringVar(&f.tlsCert, "tls-cert", "", "PEM client certificate for mTLS")
+	fs.StringVar(&f.tlsKey, "tls-key", "", "PEM key for --tls-cert")
+	fs.DurationVar(&f.timeout, "timeout", 5*time.Minute, "how long to wait for the daemon")
+	fs.StringVar(&f.output, "o", "table", "output format: table or json")
+	return f
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func (f *remoteFlags) validate() error {
+	if f.output != "table" && f.output != "json" {
+		return fmt.Errorf("unknown output format '%s'; use table or json", f.output)
+	}
+	if f.tokenStdin && f.hmacSecretStdin {
+		return errors.New("--token-stdin and --hmac-secret-stdin cannot both read stdin")
+	}
+	return nil
+}
+
+// readsStdin reports whether a credential is read from stdin, which then
+// cannot carry anything else.
+func (f *remoteFlags) readsStdin() bool {
+	return f.tokenStdin || f.hmacSecretStdin
+}
+
+func (f *remoteFlags) client() (*client.Client, error) {
+	token, hmacSecret := os.Getenv("HUBFLY_TOKEN"), os.Getenv("HUBFLY_HMAC_SECRET")
+	var err error
+	if f.tokenStdin {
+		if token, err = readSecret("API token"); err != nil {
+			return nil, err
+		}
+	}
+	if f.hmacSecretStdin {
+		if hmacSecret, err = readSecret("HMAC secret"); err != nil {
+			return nil, err
+		}
+	}
+
+	var opts []client.Option
+	if token != "" {
+		opts = append(opts, client.WithToken(token))
+	}
+	if hmacSecret != "" {
+		opts = append(opts, client.WithHMACSecret(hmacSecret))
+	}
+
+	if f.socket != "" {
+		opts = append(opts, client.WithUnixSocket(f.socket))
+	} else if f.tlsCA != "" || f.tlsCert != "" {
+		tlsConfig, err := f.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		opts = append(opts, client.WithHTTPClient(&http.Client{Transport: transport}))
+	}
+	return client.New(f.addr, opts...)
+}
+
+func (f *remoteFlags) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if f.tlsCA != "" {
+		pem, err := ioutil.ReadFile(f.tlsCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --tls-ca: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", f.tlsCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if f.tlsCert != "" || f.tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(f.tlsCert, f.tlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// parseWithName parses flags on either side of a single positional
+// argument, so both `volume stats NAME --o json` and
+// `volume stats --o json NAME` work.
+func parseWithName(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if fs.NArg() == 0 {
+		return "", errors.New("a volume name is required")
+	}
+	name := fs.Arg(0)
+	rest := fs.Args()[1:]
+	if err := fs.Parse(rest); err != nil {
+		return "", err
+	}
+	if fs.NArg() > 0 {
+		return "", fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return name, nil
+}
+
+func printJSON(value interface{}) {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(value)
+}
+
+// reportError prints a failed call and returns exit code 1. API errors
+// already carry the server's message, so only the code is added.
+func reportError(action string, err error) int {
+	var apiErr *client.Error
+	if errors.As(err, &apiErr) {
+		fmt.Fprintf(os.Stderr, "%s (%s)\n", apiErr.Message, apiErr.Code)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Failed to %s: %v\n", action, err)
+	return 1
+}
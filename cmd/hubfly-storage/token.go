@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"hubfly-storage/auth"
+	"hubfly-storage/config"
+)
+
+func runTokenCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: hubfly-storage token <issue|revoke|list> [flags]")
+		fmt.Fprintln(os.Stderr, "  issue  --name NAME --scopes SCOPE[,SCOPE...] [--selector KEY=VALUE[,KEY=VALUE...]]")
+		fmt.Fprintln(os.Stderr, "         scopes: "+scopeList()+" or all")
+		fmt.Fprintln(os.Stderr, "  revoke ID")
+		fmt.Fprintln(os.Stderr, "  list")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+
+	fs := flag.NewFlagSet("token "+args[0], flag.ContinueOnError)
+	configPath := addConfigFlag(fs)
+	fs.String("tokens-file", "", "path to the token store (default tokens_file from the configuration)")
+	name := fs.String("name", "", "name of the caller the token is issued to")
+	scopes := fs.String("scopes", "", "comma-separated scopes to grant")
+	selector := fs.String("selector", "", "restrict the token to volumes with these labels, as key=value pairs")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	cfg, err := loadConfig(&dotenv{path: config.EnvPath(*configPath)}, *configPath, fs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	store := auth.NewStore(cfg.TokensFile)
+
+	switch args[0] {
+	case "issue":
+		parsedScopes, err := auth.ParseScopes(*scopes)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		parsedSelector, err := auth.ParseSelector(*selector)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		secret, token, err := store.Issue(*name, parsedScopes, parsedSelector)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to issue token: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Issued token %s for %s with scopes %s. Store the secret now; it is not shown again.\n", token.ID, token.Name, joinScopes(token.Scopes))
+		fmt.Println(secret)
+		return 0
+	case "revoke":
+		if fs.NArg() != 1 {
+			usage()
+			return 2
+		}
+		if err := store.Revoke(fs.Arg(0)); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to revoke token: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Revoked token %s\n", fs.Arg(0))
+		return 0
+	case "list":
+		tokens, err := store.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to list tokens: %v\n", err)
+			return 1
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSCOPES\tSELECTOR\tCREATED\tREVOKED")
+		for _, token := range tokens {
+			revoked := "-"
+			if token.RevokedAt != nil {
+				revoked = token.RevokedAt.Format(time.RFC3339)
+			}
+			selector := "*"
+			if len(token.Selector) > 0 {
+				selector = token.Selector.String()
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", token.ID, token.Name, joinScopes(token.Scopes), selector, token.CreatedAt.Format(time.RFC3339), revoked)
+		}
+		tw.Flush()
+		return 0
+	default:
+		usage()
+		return 2
+	}
+}
+
+func joinScopes(scopes []auth.Scope) string {
+	parts := make([]string, len(scopes))
+	for i, scope := range scopes {
+		parts[i] = string(scope)
+	}
+	return strings.Join(parts, ",")
+}
+
+func scopeList() string {
+	return joinScopes(auth.AllScopes)
+}
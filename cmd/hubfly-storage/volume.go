@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"hubfly-storage/handlers"
+	"hubfly-storage/volume"
+)
+
+// labelsFlag collects repeated --label key=value flags.
+type labelsFlag map[string]string
+
+func (l labelsFlag) String() string {
+	return formatLabels(l)
+}
+
+func (l labelsFlag) Set(raw string) error {
+	parts := strings.SplitN(raw, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return fmt.Errorf("invalid label '%s': expected key=value", raw)
+	}
+	l[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	return nil
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "-"
+	}
+	parts := make([]string, 0, len(labels))
+	for key, value := range labels {
+		parts = append(parts, key+"="+value)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func runVolumeCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: hubfly-storage volume <create|delete|resize|stats|ls> [flags]")
+		fmt.Fprintln(os.Stderr, "  create NAME [--size 1G] [--optimization MODE] [--encryption [--encryption-key-stdin]] [--owner OWNER] [--label KEY=VALUE ...]")
+		fmt.Fprintln(os.Stderr, "  delete NAME [--delete-snapshots]")
+		fmt.Fprintln(os.Stderr, "  resize NAME --size SIZE [--shrink] [--encryption-key-stdin]")
+		fmt.Fprintln(os.Stderr, "  stats NAME")
+		fmt.Fprintln(os.Stderr, "  ls")
+		fmt.Fprintln(os.Stderr, "connection flags: --addr URL | --socket PATH, --token-stdin | --hmac-secret-stdin (default env HUBFLY_TOKEN, HUBFLY_HMAC_SECRET), --tls-ca, --tls-cert, --tls-key; output: --o table|json")
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+
+	fs := flag.NewFlagSet("volume "+args[0], flag.ContinueOnError)
+	remote := addRemoteFlags(fs)
+	size := fs.String("size", "", "volume size, such as 5G; for resize also +25% or +1G")
+	optimization := fs.String("optimization", "", "standard, high_performance or balanced")
+	encryption := fs.Bool("encryption", false, "encrypt the volume with LUKS")
+	encryptionKeyStdin := fs.Bool("encryption-key-stdin", false, "read the encryption passphrase from stdin, prompting on a terminal (default env "+encryptionKeyEnv+", then the daemon's VOLUME_ENCRYPTION_KEY)")
+	owner := fs.String("owner", "", "owner recorded in the volume metadata")
+	shrink := fs.Bool("shrink", false, "allow resize to shrink the volume")
+	withSnapshots := fs.Bool("delete-snapshots", false, "let delete remove a volume that has snapshots, deleting them with it")
+	labels := labelsFlag{}
+	fs.Var(labels, "label", "label as key=value; repeatable")
+
+	var rawName string
+	var err error
+	if args[0] == "ls" {
+		err = fs.Parse(args[1:])
+	} else {
+		rawName, err = parseWithName(fs, args[1:])
+	}
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return 2
+	}
+	var name volume.VolumeName
+	if args[0] != "ls" {
+		if name, err = volume.ParseVolumeName(rawName); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	if err := remote.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var encryptionKey string
+	if args[0] == "create" || args[0] == "resize" {
+		if *encryptionKeyStdin && remote.readsStdin() {
+			fmt.Fprintln(os.Stderr, "--encryption-key-stdin cannot share stdin with --token-stdin or --hmac-secret-stdin; pass the credential in its environment variable")
+			return 2
+		}
+		if encryptionKey, err = readEncryptionKey(*encryptionKeyStdin); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	c, err := remote.client()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), remote.timeout)
+	defer cancel()
+
+	switch args[0] {
+	case "create":
+		payload := handlers.DockerVolumePayload{
+			Name:       name,
+			DriverOpts: map[string]string{},
+			Labels:     labels,
+		}
+		setOpt(payload.DriverOpts, "size", *size)
+		setOpt(payload.DriverOpts, "optimization", *optimization)
+		setOpt(payload.DriverOpts, "encryption_key", encryptionKey)
+		setOpt(payload.DriverOpts, "owner", *owner)
+		if *encryption {
+			payload.DriverOpts["encryption"] = "true"
+		}
+
+		response, err := c.CreateVolume(ctx, payload)
+		if err != nil {
+			return reportError("create volume", err)
+		}
+		if remote.output == "json" {
+			printJSON(response)
+		} else {
+			fmt.Printf("Created volume %s\n", response.Name)
+		}
+		return 0
+
+	case "delete":
+		response, err := c.DeleteVolume(ctx, name.String(), *withSnapshots)
+		if err != nil {
+			return reportError("delete volume", err)
+		}
+		if remote.output == "json" {
+			printJSON(response)
+		} else {
+			fmt.Printf("Deleted volume %s\n", response.Name)
+		}
+		return 0
+
+	case "resize":
+		if *size == "" {
+			fmt.Fprintln(os.Stderr, "--size is required")
+			return 2
+		}
+		payload := handlers.DockerVolumePayload{
+			Name:       name,
+			DriverOpts: map[string]string{"size": *size},
+		}
+		setOpt(payload.DriverOpts, "encryption_key", encryptionKey)
+		if *shrink {
+			payload.DriverOpts["shrink"] = "true"
+		}
+
+		response, err := c.ResizeVolume(ctx, payload)
+		if err != nil {
+			return reportError("resize volume", err)
+		}
+		if remote.output == "json" {
+			printJSON(response)
+		} else {
+			fmt.Printf("Resized volume %s from %d to %d bytes\n", response.Name, response.PreviousSizeBytes, response.NewSizeBytes)
+		}
+		return 0
+
+	case "stats":
+		stats, err := c.Stats(ctx, name.String())
+		if err != nil {
+			return reportError("get volume stats", err)
+		}
+		if remote.output == "json" {
+			printJSON(stats)
+			return 0
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "NAME\t%s\n", stats.Name)
+		fmt.Fprintf(tw, "SIZE\t%s\n", stats.Size)
+		fmt.Fprintf(tw, "USED\t%s\n", stats.Used)
+		fmt.Fprintf(tw, "AVAILABLE\t%s\n", stats.Available)
+		fmt.Fprintf(tw, "USAGE\t%s\n", stats.Usage)
+		fmt.Fprintf(tw, "MOUNT PATH\t%s\n", stats.MountPath)
+		if meta := stats.Metadata; meta != nil {
+			fmt.Fprintf(tw, "OPTIMIZATION\t%s\n", meta.Optimization)
+			fmt.Fprintf(tw, "ENCRYPTED\t%t\n", meta.Encrypted)
+			fmt.Fprintf(tw, "LABELS\t%s\n", formatLabels(meta.Labels))
+			if meta.Autogrow != nil {
+				fmt.Fprintf(tw, "AUTOGROW\tat %g%% by %s up to %s\n", meta.Autogrow.ThresholdPercent, meta.Autogrow.Step, meta.Autogrow.MaxSize)
+			}
+			fmt.Fprintf(tw, "CREATED\t%s\n", meta.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		tw.Flush()
+		return 0
+
+	case "ls":
+		volumes, err := c.List(ctx)
+		if err != nil {
+			return reportError("list volumes", err)
+		}
+		if remote.output == "json" {
+			printJSON(volumes)
+			return 0
+		}
+
+		sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSIZE\tUSED\tAVAILABLE\tUSAGE\tENCRYPTED\tLABELS")
+		for _, stats := range volumes {
+			encrypted, labels := "-", "-"
+			if stats.Metadata != nil {
+				encrypted = fmt.Sprintf("%t", stats.Metadata.Encrypted)
+				labels = formatLabels(stats.Metadata.Labels)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", stats.Name, stats.Size, stats.Used, stats.Available, stats.Usage, encrypted, labels)
+		}
+		tw.Flush()
+		return 0
+
+	default:
+		usage()
+		return 2
+	}
+}
+
+// encryptionKeyEnv holds the passphrase for volume create and resize.
+const encryptionKeyEnv = "HUBFLY_ENCRYPTION_KEY"
+
+// readEncryptionKey returns the passphrase from stdin when fromStdin is set
+// and from HUBFLY_ENCRYPTION_KEY otherwise. It is never taken from a flag,
+// so it does not show up in the process list. An empty key leaves the
+// daemon's VOLUME_ENCRYPTION_KEY in charge.
+func readEncryptionKey(fromStdin bool) (string, error) {
+	if !fromStdin {
+		return os.Getenv(encryptionKeyEnv), nil
+	}
+	return readSecret("encryption passphrase")
+}
+
+// readSecret reads the first line of stdin, prompting for it without echo
+// on a terminal. what names the secret in the prompt and in errors.
+func readSecret(what string) (string, error) {
+	var secret string
+	var err error
+	if isTerminal(os.Stdin) {
+		fmt.Fprintf(os.Stderr, "%s%s: ", strings.ToUpper(what[:1]), what[1:])
+		secret, err = readHiddenLine(os.Stdin)
+		fmt.Fprintln(os.Stderr)
+	} else {
+		secret, err = readLine(os.Stdin)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read the %s: %v", what, err)
+	}
+	if secret == "" {
+		return "", fmt.Errorf("no %s on stdin", what)
+	}
+	return secret, nil
+}
+
+// readLine reads the first line of r without its line ending.
+func readLine(r io.Reader) (string, error) {
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func setOpt(opts map[string]string, key, value string) {
+	if value != "" {
+		opts[key] = value
+	}
+}
+
+func runShareCommand(args []string) int {
+	fs := flag.NewFlagSet("share", flag.ContinueOnError)
+	remote := addRemoteFlags(fs)
+	name, err := parseWithName(fs, args)
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, "usage: hubfly-storage share NAME [connection flags]")
+		}
+		return 2
+	}
+	if err := remote.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	c, err := remote.client()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), remote.timeout)
+	defer cancel()
+
+	url, err := c.ShareURL(ctx, name)
+	if err != nil {
+		return reportError("share volume", err)
+	}
+	if remote.output == "json" {
+		printJSON(handlers.URLVolumeCreateResponse{URL: url})
+	} else {
+		fmt.Println(url)
+	}
+	return 0
+}
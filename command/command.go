@@ -0,0 +1,141 @@
+// Package command runs external tools and logs them with secrets redacted.
+//
+// Arguments can be marked secret, and stdin only ever carries secrets.
+// Secret values are replaced with [REDACTED] in the logged command line, in
+// the logged output and in returned errors. Prefer SecretStdin over SecretArg whenever the tool can
+// read the secret from stdin: arguments are visible to every user on the
+// host through /proc while the process runs, and sudo closes any other file
+// descriptors before exec.
+package command
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+type arg struct {
+	value  string
+	secret bool
+}
+
+// Cmd describes one invocation of an external tool.
+type Cmd struct {
+	ctx   context.Context
+	name  string
+	args  []arg
+	stdin *string
+	dir   string
+}
+
+func New(name string, args ...string) *Cmd {
+	c := &Cmd{ctx: context.Background(), name: name}
+	return c.Arg(args...)
+}
+
+// Arg appends plain arguments.
+func (c *Cmd) Arg(values ...string) *Cmd {
+	for _, value := range values {
+		c.args = append(c.args, arg{value: value})
+	}
+	return c
+}
+
+// SecretArg appends an argument that is redacted in logs. Use it only for
+// tools that cannot read the secret from stdin.
+func (c *Cmd) SecretArg(value string) *Cmd {
+	c.args = append(c.args, arg{value: value, secret: true})
+	return c
+}
+
+// SecretStdin feeds a secret to the process; it is redacted if the tool
+// echoes it back.
+func (c *Cmd) SecretStdin(input string) *Cmd {
+	c.stdin = &input
+	return c
+}
+
+func (c *Cmd) Dir(dir string) *Cmd {
+	c.dir = dir
+	return c
+}
+
+func (c *Cmd) Context(ctx context.Context) *Cmd {
+	c.ctx = ctx
+	return c
+}
+
+// String returns the command line with secret arguments redacted.
+func (c *Cmd) String() string {
+	return c.name + " " + fmt.Sprint(c.redactedArgs())
+}
+
+// Run runs the command and returns an error wrapping the *exec.ExitError
+// together with the redacted output.
+func (c *Cmd) Run() error {
+	_, err := c.Output()
+	return err
+}
+
+// Output runs the command and returns its combined output. Secrets in the
+// output are redacted.
+func (c *Cmd) Output() (string, error) {
+	values := make([]string, len(c.args))
+	for i, a := range c.args {
+		values[i] = a.value
+	}
+
+	cmd := exec.CommandContext(c.ctx, c.name, values...)
+	cmd.Dir = c.dir
+	if c.stdin != nil {
+		cmd.Stdin = strings.NewReader(*c.stdin)
+	}
+	raw, err := cmd.CombinedOutput()
+	output := c.redact(string(raw))
+
+	log.Printf("Command: %s %v\nOutput: %s", c.name, c.redactedArgs(), output)
+	if err != nil {
+		return output, fmt.Errorf("%w: %s", err, output)
+	}
+	return output, nil
+}
+
+func (c *Cmd) redactedArgs() []string {
+	args := make([]string, len(c.args))
+	for i, a := range c.args {
+		if a.secret {
+			args[i] = redacted
+		} else {
+			args[i] = a.value
+		}
+	}
+	return args
+}
+
+func (c *Cmd) redact(text string) string {
+	for _, secret := range c.secrets() {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	return text
+}
+
+func (c *Cmd) secrets() []string {
+	var secrets []string
+	for _, a := range c.args {
+		if a.secret && a.value != "" {
+			secrets = append(secrets, a.value)
+		}
+	}
+	if c.stdin != nil {
+		for _, line := range strings.Split(*c.stdin, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				secrets = append(secrets, line)
+			}
+		}
+	}
+	return secrets
+}
@@ -0,0 +1,44 @@
+package command
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tools such as cryptsetup echo their input back in error messages, so the
+// returned error must not carry a secret argument or stdin line.
+func TestRunRedactsSecretsFromErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		cmd    *Cmd
+		secret string
+	}{
+		{
+			name:   "secret argument",
+			cmd:    New("sh", "-c", `echo "bad key $0"; exit 1`).SecretArg("hunter2"),
+			secret: "hunter2",
+		},
+		{
+			name:   "secret stdin",
+			cmd:    New("sh", "-c", `read key; echo "bad key $key"; exit 1`).SecretStdin("s3cret-passphrase\n"),
+			secret: "s3cret-passphrase",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cmd.Run()
+			if err == nil {
+				t.Fatal("Run succeeded, want the exit error")
+			}
+			if strings.Contains(err.Error(), tt.secret) {
+				t.Errorf("error %q contains the secret", err)
+			}
+			if !strings.Contains(err.Error(), "bad key "+redacted) {
+				t.Errorf("error %q does not carry the redacted output", err)
+			}
+			if strings.Contains(tt.cmd.String(), tt.secret) {
+				t.Errorf("command line %q contains the secret", tt.cmd.String())
+			}
+		})
+	}
+}
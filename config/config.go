@@ -0,0 +1,427 @@
+// Package config loads the daemon settings. Each setting is taken from, in
+// increasing order of precedence, the built-in default, the TOML config
+// file, its environment variable and its command-line flag.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"hubfly-storage/filebrowser"
+	"hubfly-storage/plugin"
+	"hubfly-storage/server"
+	"hubfly-storage/volume"
+
+	"github.com/BurntSushi/toml"
+)
+
+const (
+	// DefaultDir holds the default config file, .env and token store.
+	DefaultDir = "/etc/hubfly-storage"
+	// DefaultPath is read when no config file is named and it exists.
+	DefaultPath = DefaultDir + "/config.toml"
+	// DefaultBaseDir holds the volume images and service state.
+	DefaultBaseDir = "/var/lib/hubfly-storage"
+	// PathEnv names a config file when --config is not given.
+	PathEnv = "HUBFLY_CONFIG"
+	// EnvFile is the name of the .env file next to the config file.
+	EnvFile = ".env"
+	// LegacyBaseDir is where versions before the absolute defaults kept
+	// their volumes, relative to the working directory.
+	LegacyBaseDir = "docker/volumes"
+)
+
+// Config holds every setting as the string a user would type, so that the
+// file, the environment and flags share one parser per setting. Fields
+// tagged path are resolved against the config file's directory when they
+// come from the file; fields tagged reload are applied by a reload, while
+// changes to the others need a restart.
+type Config struct {
+	Listen           string `toml:"listen" flag:"listen" env:"HUBFLY_LISTEN"`
+	BaseDir          string `toml:"base_dir" flag:"base-dir" env:"HUBFLY_BASE_DIR" path:"true"`
+	TokensFile       string `toml:"tokens_file" flag:"tokens-file" env:"HUBFLY_TOKENS_FILE" path:"true" reload:"true"`
+	RequireAuth      string `toml:"require_auth" flag:"require-auth" env:"HUBFLY_REQUIRE_AUTH" reload:"true"`
+	AuditLog         string `toml:"audit_log" flag:"audit-log" env:"HUBFLY_AUDIT_LOG" path:"true"`
+	PluginSocket     string `toml:"plugin_socket" flag:"plugin-socket" env:"HUBFLY_PLUGIN_SOCKET" path:"true"`
+	AutogrowInterval string `toml:"autogrow_interval" flag:"autogrow-interval" env:"HUBFLY_AUTOGROW_INTERVAL"`
+
+	Socket      SocketConfig      `toml:"socket"`
+	TLS         TLSConfig         `toml:"tls"`
+	Volumes     VolumesConfig     `toml:"volumes"`
+	FileBrowser FileBrowserConfig `toml:"filebrowser"`
+
+	// Path is the config file that was read, or empty.
+	Path string `toml:"-"`
+	// LegacyBaseDir is set when BaseDir is LegacyBaseDir because base_dir
+	// was not configured and that directory exists.
+	LegacyBaseDir bool `toml:"-"`
+
+	// set holds the dotted keys given by the file, environment or flags.
+	set map[string]bool
+}
+
+// markSet records that the setting with key was given explicitly.
+func (c *Config) markSet(key []string) {
+	if c.set == nil {
+		c.set = map[string]bool{}
+	}
+	c.set[strings.Join(key, ".")] = true
+}
+
+type SocketConfig struct {
+	Path      string `toml:"path" flag:"socket" env:"HUBFLY_SOCKET" path:"true"`
+	Owner     string `toml:"owner" flag:"socket-owner" env:"HUBFLY_SOCKET_OWNER"`
+	Mode      string `toml:"mode" flag:"socket-mode" env:"HUBFLY_SOCKET_MODE"`
+	AllowUIDs string `toml:"allow_uids" flag:"socket-allow-uids" env:"HUBFLY_SOCKET_ALLOW_UIDS"`
+}
+
+type TLSConfig struct {
+	Cert     string `toml:"cert" flag:"tls-cert" env:"HUBFLY_TLS_CERT" path:"true"`
+	Key      string `toml:"key" flag:"tls-key" env:"HUBFLY_TLS_KEY" path:"true"`
+	ClientCA string `toml:"client_ca" flag:"tls-client-ca" env:"HUBFLY_TLS_CLIENT_CA" path:"true"`
+}
+
+type VolumesConfig struct {
+	DefaultSize         string `toml:"default_size" flag:"default-size" env:"HUBFLY_DEFAULT_SIZE" reload:"true"`
+	DefaultOptimization string `toml:"default_optimization" flag:"default-optimization" env:"HUBFLY_DEFAULT_OPTIMIZATION" reload:"true"`
+	MaxSize             string `toml:"max_size" flag:"max-volume-size" env:"HUBFLY_MAX_VOLUME_SIZE" reload:"true"`
+}
+
+type FileBrowserConfig struct {
+	URL       string `toml:"url" flag:"filebrowser-url" env:"FILEBROWSER_URL" reload:"true"`
+	AdminUser string `toml:"admin_user" flag:"filebrowser-admin-user" env:"FILEBROWSER_ADMIN_USER" reload:"true"`
+	Binary    string `toml:"binary" flag:"filebrowser-binary" env:"HUBFLY_FILEBROWSER_BINARY" path:"true"`
+}
+
+// Default returns the built-in settings. An empty AuditLog means
+// .audit/audit.log under BaseDir.
+func Default() *Config {
+	limits := volume.DefaultLimits()
+	return &Config{
+		Listen:           ":10007",
+		BaseDir:          DefaultBaseDir,
+		TokensFile:       filepath.Join(DefaultDir, "tokens.json"),
+		RequireAuth:      "true",
+		PluginSocket:     plugin.DefaultSocketPath,
+		AutogrowInterval: time.Minute.String(),
+		Socket:           SocketConfig{Mode: "0660"},
+		Volumes: VolumesConfig{
+			DefaultSize:         limits.DefaultSize,
+			DefaultOptimization: string(limits.DefaultOptimization),
+		},
+		FileBrowser: FileBrowserConfig{
+			URL:       filebrowser.DefaultURL,
+			AdminUser: filebrowser.DefaultAdminUser,
+		},
+	}
+}
+
+// setting is one leaf of Config with its TOML key and overrides.
+type setting struct {
+	key    []string
+	flag   string
+	env    string
+	path   bool
+	reload bool
+	value  *string
+}
+
+func (c *Config) settings() []setting {
+	var settings []setting
+	var walk func(v reflect.Value, prefix []string)
+	walk = func(v reflect.Value, prefix []string) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			key := field.Tag.Get("toml")
+			if key == "" || key == "-" {
+				continue
+			}
+			path := append(append([]string{}, prefix...), key)
+			if field.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), path)
+				continue
+			}
+			settings = append(settings, setting{
+				key:    path,
+				flag:   field.Tag.Get("flag"),
+				env:    field.Tag.Get("env"),
+				path:   field.Tag.Get("path") == "true",
+				reload: field.Tag.Get("reload") == "true",
+				value:  v.Field(i).Addr().Interface().(*string),
+			})
+		}
+	}
+	walk(reflect.ValueOf(c).Elem(), nil)
+	return settings
+}
+
+// Load builds the configuration. path names the config file; when empty,
+// $HUBFLY_CONFIG and then DefaultPath are tried, and a missing DefaultPath
+// is not an error. Only flags of fs that were set on the command line
+// override the other sources.
+func Load(path string, fs *flag.FlagSet) (*Config, error) {
+	cfg := Default()
+
+	if path == "" {
+		path = os.Getenv(PathEnv)
+	}
+	if path == "" {
+		if _, err := os.Stat(DefaultPath); err == nil {
+			path = DefaultPath
+		}
+	}
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range cfg.settings() {
+		if value := os.Getenv(s.env); value != "" {
+			*s.value = value
+			cfg.markSet(s.key)
+		}
+	}
+
+	if fs != nil {
+		byFlag := map[string]setting{}
+		for _, s := range cfg.settings() {
+			byFlag[s.flag] = s
+		}
+		fs.Visit(func(f *flag.Flag) {
+			if s, ok := byFlag[f.Name]; ok {
+				*s.value = f.Value.String()
+				cfg.markSet(s.key)
+			}
+		})
+	}
+
+	// An upgraded host that never set base_dir keeps its volumes in
+	// ./docker/volumes; moving to the new default would lose track of them.
+	if !cfg.set["base_dir"] {
+		if info, err := os.Stat(LegacyBaseDir); err == nil && info.IsDir() {
+			if abs, err := filepath.Abs(LegacyBaseDir); err == nil {
+				cfg.BaseDir = abs
+				cfg.LegacyBaseDir = true
+			}
+		}
+	}
+
+	if cfg.AuditLog == "" {
+		cfg.AuditLog = filepath.Join(cfg.BaseDir, ".audit", "audit.log")
+	}
+	return cfg, nil
+}
+
+// filePath returns the config file Load reads for path: path itself when
+// set, then $HUBFLY_CONFIG, then DefaultPath.
+func filePath(path string) string {
+	if path == "" {
+		path = os.Getenv(PathEnv)
+	}
+	if path == "" {
+		path = DefaultPath
+	}
+	return path
+}
+
+// EnvPath returns the .env file that belongs to the config file Load reads
+// for path. It sits in the same directory, so it does not depend on the
+// working directory. Without a named config file and without
+// /etc/hubfly-storage/.env, a ./.env left by an earlier version is used
+// instead, so its FileBrowser password and secrets are kept.
+func EnvPath(path string) string {
+	envPath := filepath.Join(filepath.Dir(filePath(path)), EnvFile)
+	if path != "" || os.Getenv(PathEnv) != "" {
+		return envPath
+	}
+	if _, err := os.Stat(envPath); !os.IsNotExist(err) {
+		return envPath
+	}
+	if _, err := os.Stat(EnvFile); err != nil {
+		return envPath
+	}
+	legacy, err := filepath.Abs(EnvFile)
+	if err != nil {
+		return envPath
+	}
+	return legacy
+}
+
+func (c *Config) readFile(path string) error {
+	var raw map[string]interface{}
+	if _, err := toml.DecodeFile(path, &raw); err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+	stringifyScalars(raw)
+	var normalized bytes.Buffer
+	if err := toml.NewEncoder(&normalized).Encode(raw); err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+
+	var fromFile Config
+	meta, err := toml.Decode(normalized.String(), &fromFile)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return fmt.Errorf("unknown settings in %s: %s", path, strings.Join(keys, ", "))
+	}
+
+	dir := filepath.Dir(path)
+	fileSettings := fromFile.settings()
+	for i, s := range c.settings() {
+		if !meta.IsDefined(s.key...) {
+			continue
+		}
+		c.markSet(s.key)
+		value := *fileSettings[i].value
+		if s.path && value != "" && !filepath.IsAbs(value) {
+			value = filepath.Join(dir, value)
+		}
+		*s.value = value
+	}
+	c.Path = path
+	return nil
+}
+
+// stringifyScalars turns booleans and numbers into strings, so that
+// require_auth = true reads like require_auth = "true".
+func stringifyScalars(table map[string]interface{}) {
+	for key, value := range table {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			stringifyScalars(v)
+		case bool, int64, float64:
+			table[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// Changes lists the settings that differ between old and c by their dotted
+// keys, split into those a reload applies and those that need a restart.
+func (c *Config) Changes(old *Config) (reloaded, restart []string) {
+	oldSettings := old.settings()
+	for i, s := range c.settings() {
+		if *s.value == *oldSettings[i].value {
+			continue
+		}
+		key := strings.Join(s.key, ".")
+		if s.reload {
+			reloaded = append(reloaded, key)
+		} else {
+			restart = append(restart, key)
+		}
+	}
+	return reloaded, restart
+}
+
+// Validate checks every setting and reports all problems at once.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if c.Listen == "" && c.Socket.Path == "" {
+		problems = append(problems, "nothing to serve: both listen and socket.path are empty")
+	}
+	if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid listen address '%s': %v", c.Listen, err))
+		}
+	}
+	if strings.TrimSpace(c.BaseDir) == "" {
+		problems = append(problems, "base_dir is required")
+	}
+	_, err := c.AuthRequired()
+	check(err)
+	_, err = c.Interval()
+	check(err)
+	_, err = c.SocketOptions()
+	check(err)
+	check(c.TLSFiles().Validate())
+	_, err = c.Limits()
+	check(err)
+
+	if parsed, err := url.Parse(c.FileBrowser.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		problems = append(problems, fmt.Sprintf("invalid filebrowser.url '%s': expected an http or https URL", c.FileBrowser.URL))
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// AuthRequired is the parsed require_auth.
+func (c *Config) AuthRequired() (bool, error) {
+	required, err := strconv.ParseBool(strings.TrimSpace(c.RequireAuth))
+	if err != nil {
+		return false, fmt.Errorf("invalid require_auth '%s': expected true or false", c.RequireAuth)
+	}
+	return required, nil
+}
+
+// Interval is the parsed autogrow_interval.
+func (c *Config) Interval() (time.Duration, error) {
+	interval, err := time.ParseDuration(c.AutogrowInterval)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid autogrow_interval '%s': expected a positive duration such as 1m", c.AutogrowInterval)
+	}
+	return interval, nil
+}
+
+func (c *Config) SocketOptions() (server.UnixSocketOptions, error) {
+	var opts server.UnixSocketOptions
+	var err error
+	if opts.UID, opts.GID, err = server.ParseOwner(c.Socket.Owner); err != nil {
+		return opts, err
+	}
+	if opts.Mode, err = server.ParseMode(c.Socket.Mode); err != nil {
+		return opts, err
+	}
+	if opts.AllowedUIDs, err = server.ParseUIDs(c.Socket.AllowUIDs); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+func (c *Config) TLSFiles() server.TLSFiles {
+	return server.TLSFiles{CertFile: c.TLS.Cert, KeyFile: c.TLS.Key, ClientCAFile: c.TLS.ClientCA}
+}
+
+func (c *Config) Limits() (volume.Limits, error) {
+	return volume.ParseLimits(c.Volumes.DefaultSize, c.Volumes.DefaultOptimization, c.Volumes.MaxSize)
+}
+
+// Write prints the configuration as TOML that Load accepts.
+func (c *Config) Write(w io.Writer) error {
+	source := "built-in defaults"
+	if c.Path != "" {
+		source = c.Path
+	}
+	if _, err := fmt.Fprintf(w, "# Effective configuration from %s, the environment and flags.\n", source); err != nil {
+		return err
+	}
+	encoder := toml.NewEncoder(w)
+	encoder.Indent = ""
+	return encoder.Encode(c)
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfig(t, `
+listen = ":1000"
+autogrow_interval = "2m"
+
+[volumes]
+default_size = "2G"
+max_size = "20G"
+`)
+	t.Setenv("HUBFLY_LISTEN", ":2000")
+	t.Setenv("HUBFLY_DEFAULT_SIZE", "3G")
+
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.String("listen", ":10007", "")
+	fs.String("default-size", "1G", "")
+	fs.String("max-volume-size", "", "")
+	if err := fs.Parse([]string{"--listen", ":3000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		setting, got, want string
+	}{
+		{"listen (flag over env over file)", cfg.Listen, ":3000"},
+		{"volumes.default_size (env over file)", cfg.Volumes.DefaultSize, "3G"},
+		{"volumes.max_size (file; unset flag ignored)", cfg.Volumes.MaxSize, "20G"},
+		{"autogrow_interval (file over default)", cfg.AutogrowInterval, "2m"},
+		{"socket.mode (default)", cfg.Socket.Mode, Default().Socket.Mode},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.setting, tt.got, tt.want)
+		}
+	}
+	if cfg.Path != path {
+		t.Errorf("Path = %q, want %q", cfg.Path, path)
+	}
+}
+
+func TestLoadResolvesRelativePathsAgainstConfigDir(t *testing.T) {
+	path := writeConfig(t, `
+base_dir = "volumes"
+tokens_file = "/srv/tokens.json"
+listen = "relative:1"
+
+[tls]
+cert = "certs/server.pem"
+`)
+	t.Setenv("HUBFLY_TLS_KEY", "certs/server-key.pem")
+
+	cfg, err := Load(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(path)
+	tests := []struct {
+		setting, got, want string
+	}{
+		{"base_dir", cfg.BaseDir, filepath.Join(dir, "volumes")},
+		{"tls.cert", cfg.TLS.Cert, filepath.Join(dir, "certs", "server.pem")},
+		{"tokens_file (absolute)", cfg.TokensFile, "/srv/tokens.json"},
+		{"listen (not a path)", cfg.Listen, "relative:1"},
+		// Only values from the file are relative to its directory.
+		{"tls.key (from the environment)", cfg.TLS.Key, "certs/server-key.pem"},
+		{"audit_log (default under base_dir)", cfg.AuditLog, filepath.Join(dir, "volumes", ".audit", "audit.log")},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.setting, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownKeys(t *testing.T) {
+	path := writeConfig(t, `
+listen = ":1000"
+base_directory = "/srv"
+
+[volumes]
+max = "10G"
+`)
+	_, err := Load(path, nil)
+	if err == nil {
+		t.Fatal("Load accepted unknown keys")
+	}
+	for _, key := range []string{"base_directory", "volumes.max"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error %q does not name %s", err, key)
+		}
+	}
+}
+
+func TestLoadAcceptsUnquotedScalars(t *testing.T) {
+	path := writeConfig(t, `
+require_auth = false
+
+[socket]
+allow_uids = 1000
+`)
+	cfg, err := Load(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.RequireAuth != "false" || cfg.Socket.AllowUIDs != "1000" {
+		t.Errorf("require_auth = %q, socket.allow_uids = %q", cfg.RequireAuth, cfg.Socket.AllowUIDs)
+	}
+	if required, err := cfg.AuthRequired(); err != nil || required {
+		t.Errorf("AuthRequired = %v, %v; want false", required, err)
+	}
+}
+
+func TestStringifyScalars(t *testing.T) {
+	table := map[string]interface{}{
+		"flag":   true,
+		"count":  int64(42),
+		"ratio":  1.5,
+		"name":   "text",
+		"nested": map[string]interface{}{"enabled": false, "port": int64(8080)},
+	}
+	stringifyScalars(table)
+
+	want := map[string]interface{}{
+		"flag":  "true",
+		"count": "42",
+		"ratio": "1.5",
+		"name":  "text",
+	}
+	for key, value := range want {
+		if table[key] != value {
+			t.Errorf("%s = %#v, want %#v", key, table[key], value)
+		}
+	}
+	nested := table["nested"].(map[string]interface{})
+	if nested["enabled"] != "false" || nested["port"] != "8080" {
+		t.Errorf("nested = %#v", nested)
+	}
+}
+
+// An upgraded host without base_dir keeps using ./docker/volumes, but an
+// explicit base_dir always wins.
+func TestLoadKeepsLegacyBaseDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, LegacyBaseDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	t.Setenv(PathEnv, "")
+
+	cfg, err := Load("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BaseDir != filepath.Join(dir, LegacyBaseDir) || !cfg.LegacyBaseDir {
+		t.Errorf("BaseDir = %q (legacy %v), want %s", cfg.BaseDir, cfg.LegacyBaseDir, filepath.Join(dir, LegacyBaseDir))
+	}
+
+	t.Setenv("HUBFLY_BASE_DIR", "/srv/volumes")
+	if cfg, err = Load("", nil); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.BaseDir != "/srv/volumes" || cfg.LegacyBaseDir {
+		t.Errorf("with base_dir set: BaseDir = %q (legacy %v), want /srv/volumes", cfg.BaseDir, cfg.LegacyBaseDir)
+	}
+}
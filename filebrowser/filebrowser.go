@@ -14,15 +14,41 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"hubfly-storage/command"
+	"hubfly-storage/volume"
 )
 
 const (
-	defaultFileBrowserURL  = "http://localhost:10001"
-	defaultFileBrowserUser = "admin"
-	defaultBinaryPath      = "/hubfly-tool-manager/tools/filebrowser/filebrowser"
+	DefaultURL        = "http://localhost:10001"
+	DefaultAdminUser  = "admin"
+	defaultBinaryPath = "/hubfly-tool-manager/tools/filebrowser/filebrowser"
 )
 
+// Settings locate FileBrowser and its admin account. They are read on every
+// use, so SetSettings takes effect for the next request.
+type Settings struct {
+	URL       string
+	AdminUser string
+	AdminPass string
+}
+
+var currentSettings atomic.Value
+
+func init() {
+	currentSettings.Store(Settings{URL: DefaultURL, AdminUser: DefaultAdminUser})
+}
+
+func CurrentSettings() Settings {
+	return currentSettings.Load().(Settings)
+}
+
+func SetSettings(settings Settings) {
+	currentSettings.Store(settings)
+}
+
 type Health struct {
 	Running bool   `json:"running"`
 	Version string `json:"version,omitempty"`
@@ -45,10 +71,17 @@ func EnsureEnvFile(path string) error {
 		return err
 	}
 
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// The URL and admin user normally come from the config file; set them
+	// here only to override it.
 	content := strings.Join([]string{
-		"FILEBROWSER_URL=http://localhost:10001",
-		"FILEBROWSER_ADMIN_USER=admin",
+		"# FILEBROWSER_URL=" + DefaultURL,
+		"# FILEBROWSER_ADMIN_USER=" + DefaultAdminUser,
 		"FILEBROWSER_ADMIN_PASS=''",
+		"HUBFLY_HMAC_SECRET=''",
 		"",
 	}, "\n")
 
@@ -56,14 +89,14 @@ func EnsureEnvFile(path string) error {
 }
 
 func BootstrapAdminPassword(envPath, requestedBinaryPath string) {
-	adminPass := strings.TrimSpace(os.Getenv("FILEBROWSER_ADMIN_PASS"))
-	if adminPass != "" {
+	settings := CurrentSettings()
+	if strings.TrimSpace(settings.AdminPass) != "" {
 		return
 	}
 
-	url := os.Getenv("FILEBROWSER_URL")
+	url := settings.URL
 	if strings.TrimSpace(url) == "" {
-		url = defaultFileBrowserURL
+		url = DefaultURL
 	}
 
 	if !isFileBrowserRunning(url) {
@@ -106,12 +139,15 @@ func BootstrapAdminPassword(envPath, requestedBinaryPath string) {
 	}
 
 	_ = os.Setenv("FILEBROWSER_ADMIN_PASS", newPassword)
+	settings = CurrentSettings()
+	settings.AdminPass = newPassword
+	SetSettings(settings)
 	log.Printf("FileBrowser admin password was generated and persisted")
 }
 
 func Probe(url, requestedBinaryPath string) Health {
 	if strings.TrimSpace(url) == "" {
-		url = defaultFileBrowserURL
+		url = DefaultURL
 	}
 
 	health := Health{Running: isFileBrowserRunning(url), URL: url}
@@ -195,11 +231,15 @@ func runFileBrowserUpdatePassword(binaryPath, password string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, binaryPath, "--database", databasePath, "users", "update", "admin", "-p", password)
-	cmd.Dir = filepath.Dir(binaryPath)
-	output, err := cmd.CombinedOutput()
+	// The FileBrowser CLI only accepts the password as a flag, so it is
+	// marked secret to keep it out of the logs.
+	err = command.New(binaryPath, "--database", databasePath, "users", "update", "admin", "-p").
+		SecretArg(password).
+		Dir(filepath.Dir(binaryPath)).
+		Context(ctx).
+		Run()
 	if err != nil {
-		return fmt.Errorf("filebrowser users update admin failed: %v: %s", err, strings.TrimSpace(string(output)))
+		return fmt.Errorf("filebrowser users update admin failed: %v", err)
 	}
 
 	return nil
@@ -279,10 +319,17 @@ func ResolveBinaryPath(requestedBinaryPath string) string {
 	return resolveBinaryPath(requestedBinaryPath)
 }
 
-func EnsureVolumeScope(binaryPath, baseDir, volumeName string) (string, error) {
+// EnsureVolumeScope links the volume into the FileBrowser root and returns
+// the scope path for a temporary user. The name has been validated, so the
+// link always lands directly inside the scope directory.
+func EnsureVolumeScope(binaryPath, baseDir string, name volume.VolumeName) (string, error) {
 	if strings.TrimSpace(binaryPath) == "" {
 		return "", errors.New("filebrowser binary path is empty")
 	}
+	if name == "" {
+		return "", errors.New("volume name is required")
+	}
+	volumeName := name.String()
 
 	fileBrowserRoot := filepath.Dir(binaryPath)
 	scopeRoot := filepath.Join(fileBrowserRoot, "hubfly-storage-volumes")
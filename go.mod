@@ -2,4 +2,7 @@ module hubfly-storage
 
 go 1.17
 
-require github.com/joho/godotenv v1.5.1
+require (
+	github.com/BurntSushi/toml v1.2.1
+	github.com/joho/godotenv v1.5.1
+)
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"hubfly-storage/auth"
+	"hubfly-storage/volume"
+)
+
+// authorizeVolume checks that the caller's label selector matches the stored
+// labels of an existing volume. It writes a 403 and returns false otherwise.
+// Volumes that cannot be read are denied to restricted callers so they do not
+// learn which names exist.
+func authorizeVolume(w http.ResponseWriter, r *http.Request, baseDir string, name volume.VolumeName) bool {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !principal.Restricted() {
+		return true
+	}
+
+	meta, err := volume.GetVolumeMetadata(name, baseDir)
+	if err != nil || !principal.CanAccess(meta.Labels) {
+		handleError(w, fmt.Sprintf("Principal '%s' is not allowed to access volume '%s'", principal.Name, name), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// authorizeLabels checks that a volume about to be created with labels
+// would be visible to the caller.
+func authorizeLabels(w http.ResponseWriter, r *http.Request, name string, labels map[string]string) bool {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if principal.CanAccess(labels) {
+		return true
+	}
+	handleError(w, fmt.Sprintf("Principal '%s' may only create volumes labeled %s, but volume '%s' is not", principal.Name, principal.Selector, name), http.StatusForbidden)
+	return false
+}
+
+// canAccessVolume reports whether the caller may see a volume, without
+// writing a response. name comes from a record rather than a request, so a
+// name that does not parse is denied to restricted callers.
+func canAccessVolume(r *http.Request, baseDir, name string) bool {
+	principal, _ := auth.PrincipalFromContext(r.Context())
+	if !principal.Restricted() {
+		return true
+	}
+	volumeName, err := volume.ParseVolumeName(name)
+	if err != nil {
+		return false
+	}
+	meta, err := volume.GetVolumeMetadata(volumeName, baseDir)
+	return err == nil && principal.CanAccess(meta.Labels)
+}
+
+func principalName(r *http.Request) string {
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		return principal.Name
+	}
+	return ""
+}
+
+func principalTokenID(r *http.Request) string {
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		return principal.TokenID
+	}
+	return ""
+}
+
+// submittedByCaller reports whether a job or audit entry was recorded for
+// the token making the request. Token names are free text and need not be
+// unique, so only the token ID identifies the caller.
+func submittedByCaller(r *http.Request, tokenID string) bool {
+	return tokenID != "" && tokenID == principalTokenID(r)
+}
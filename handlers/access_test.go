@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"hubfly-storage/audit"
+	"hubfly-storage/auth"
+	"hubfly-storage/jobs"
+)
+
+// Two restricted tokens that share a name must not see each other's jobs or
+// audit entries on volumes outside their selectors.
+func TestOwnJobsAndAuditEntriesMatchTokenID(t *testing.T) {
+	baseDir := t.TempDir()
+	owner := &auth.Principal{Name: "agent", TokenID: "aaaa", Scopes: auth.AllScopes, Selector: auth.Selector{"tenant": "acme"}}
+	namesake := &auth.Principal{Name: "agent", TokenID: "bbbb", Scopes: auth.AllScopes, Selector: auth.Selector{"tenant": "acme"}}
+
+	jobManager, err := jobs.NewManager(filepath.Join(baseDir, ".jobs"), 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := jobManager.Submit("create", "private", owner.Name, owner.TokenID, func(func(string)) (interface{}, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auditLog, err := audit.Open(filepath.Join(baseDir, "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auditLog.Append(audit.Entry{Time: time.Now(), Principal: owner.Name, TokenID: owner.TokenID, Operation: "create", Volume: "private", Outcome: audit.OutcomeSuccess}); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(handler http.HandlerFunc, target string, principal *auth.Principal) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+	auditEntries := func(principal *auth.Principal) []audit.Entry {
+		rec := serve(GetAuditHandler(baseDir, auditLog), "/audit", principal)
+		var entries []audit.Entry
+		if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+			t.Fatalf("GET /audit: %v: %s", err, rec.Body.String())
+		}
+		return entries
+	}
+
+	getJob := GetJobHandler(baseDir, jobManager)
+	if rec := serve(getJob, "/jobs/"+job.ID, owner); rec.Code != http.StatusOK {
+		t.Errorf("submitting token: GET /jobs/%s = %d, want 200", job.ID, rec.Code)
+	}
+	if rec := serve(getJob, "/jobs/"+job.ID, namesake); rec.Code != http.StatusNotFound {
+		t.Errorf("token with the same name: GET /jobs/%s = %d, want 404", job.ID, rec.Code)
+	}
+
+	if entries := auditEntries(owner); len(entries) != 1 {
+		t.Errorf("submitting token sees %d audit entries, want 1", len(entries))
+	}
+	if entries := auditEntries(namesake); len(entries) != 0 {
+		t.Errorf("token with the same name sees %d audit entries, want 0", len(entries))
+	}
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"hubfly-storage/audit"
+)
+
+// GetAuditHandler serves GET /audit?volume=&since=&until=. since and until
+// take RFC 3339 timestamps or durations before now, such as 24h.
+func GetAuditHandler(baseDir string, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			handleError(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		now := time.Now()
+		since, err := audit.ParseTime(query.Get("since"), now)
+		if err != nil {
+			handleError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		until, err := audit.ParseTime(query.Get("until"), now)
+		if err != nil {
+			handleError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("Received request for audit entries (volume=%q since=%q until=%q)", query.Get("volume"), query.Get("since"), query.Get("until"))
+
+		entries, err := auditLog.Query(audit.Filter{Volume: query.Get("volume"), Since: since, Until: until})
+		if err != nil {
+			handleError(w, fmt.Sprintf("Failed to read audit log: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		visible := make([]audit.Entry, 0, len(entries))
+		for _, entry := range entries {
+			if submittedByCaller(r, entry.TokenID) || canAccessVolume(r, baseDir, entry.Volume) {
+				visible = append(visible, entry)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(visible)
+	}
+}
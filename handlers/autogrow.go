@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"hubfly-storage/volume"
+)
+
+// SetAutogrowHandler sets or clears a volume's autogrow policy. Passing
+// DriverOpts.autogrow=false clears it; otherwise autogrow_threshold,
+// autogrow_step and autogrow_max are required.
+func SetAutogrowHandler(baseDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload DockerVolumePayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			handleError(w, fmt.Sprintf("Invalid JSON payload: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		policy, enabled, ok := autogrowOptions(w, payload.DriverOpts)
+		if !ok {
+			return
+		}
+
+		log.Printf("Received request to set autogrow policy for volume: %s (enabled=%t)", payload.Name, enabled)
+		if !authorizeVolume(w, r, baseDir, payload.Name) {
+			return
+		}
+
+		meta, err := volume.SetAutogrowPolicy(payload.Name, baseDir, policy)
+		if err != nil {
+			handleVolumeError(w, "Failed to set autogrow policy", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(AutogrowResponse{
+			Status:   "success",
+			Name:     payload.Name.String(),
+			Autogrow: meta.Autogrow,
+		})
+	}
+}
+
+// autogrowOptions reads an autogrow policy from DriverOpts. A nil policy
+// with enabled=false means autogrow=false was passed. It writes a 400 and
+// returns false when the options are invalid.
+func autogrowOptions(w http.ResponseWriter, opts map[string]string) (*volume.AutogrowPolicy, bool, bool) {
+	enabled := true
+	if raw := strings.TrimSpace(opts["autogrow"]); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			handleError(w, "Invalid autogrow value: expected one of true/false/1/0", http.StatusBadRequest)
+			return nil, false, false
+		}
+		enabled = parsed
+	}
+	if !enabled {
+		return nil, false, true
+	}
+
+	policy, err := volume.ParseAutogrowPolicy(opts["autogrow_threshold"], opts["autogrow_step"], opts["autogrow_max"])
+	if err != nil {
+		handleError(w, fmt.Sprintf("Invalid autogrow policy: %v", err), http.StatusBadRequest)
+		return nil, false, false
+	}
+	return policy, true, true
+}
+
+func GetAutogrowEventsHandler(baseDir string, watcher *volume.AutogrowWatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		events := make([]volume.AutogrowEvent, 0)
+		for _, event := range watcher.RecentEvents() {
+			if canAccessVolume(r, baseDir, event.Volume) {
+				events = append(events, event)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(events)
+	}
+}
@@ -6,12 +6,14 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"hubfly-storage/apierror"
+	"hubfly-storage/auth"
 	"hubfly-storage/filebrowser"
+	"hubfly-storage/jobs"
 	"hubfly-storage/volume"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 )
@@ -73,14 +75,14 @@ type GetTokenResponse struct {
 }
 
 type URLVolumeCreateRequest struct {
-	Name string `json:"name"`
+	Name volume.VolumeName `json:"name"`
 }
 
 type DockerVolumePayload struct {
-	Name       string            `json:"Name"`
-	Driver     string            `json:"Driver"`
-	DriverOpts map[string]string `json:"DriverOpts"`
-	Labels     map[string]string `json:"Labels"`
+	Name       volume.VolumeName `json:"Name,omitempty"`
+	Driver     string            `json:"Driver,omitempty"`
+	DriverOpts map[string]string `json:"DriverOpts,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
 }
 
 type FileBrowserHealth struct {
@@ -91,10 +93,34 @@ type FileBrowserHealth struct {
 
 func handleError(w http.ResponseWriter, msg string, statusCode int) {
 	log.Println("❌ " + msg)
-	http.Error(w, msg, statusCode)
+	apierror.Write(w, statusCode, apierror.CodeForStatus(statusCode), msg)
 }
 
-func CreateVolumeHandler(baseDir string) http.HandlerFunc {
+// handleVolumeError reports a failed volume operation with the error's code
+// and the matching status.
+func handleVolumeError(w http.ResponseWriter, action string, err error) {
+	msg := fmt.Sprintf("%s: %v", action, err)
+	code := volume.ErrorCode(err)
+	log.Println("❌ " + msg)
+	apierror.Write(w, statusForCode(code), code, msg)
+}
+
+func statusForCode(code string) int {
+	switch code {
+	case volume.CodeInvalidRequest, volume.CodeEncryptionKeyMissing:
+		return http.StatusBadRequest
+	case volume.CodeVolumeNotFound, volume.CodeSnapshotNotFound:
+		return http.StatusNotFound
+	case volume.CodeVolumeExists, volume.CodeSnapshotExists, volume.CodeOperationInProgress, volume.CodeVolumeHasSnapshots:
+		return http.StatusConflict
+	case volume.CodeInsufficientHostSpace:
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func CreateVolumeHandler(baseDir string, jobManager *jobs.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var payload DockerVolumePayload
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -105,60 +131,35 @@ func CreateVolumeHandler(baseDir string) http.HandlerFunc {
 
 		log.Printf("Received request to create volume: %s", payload.Name)
 
-		size := payload.DriverOpts["size"]
-		if size == "" {
-			size = "1G"
-		}
-
-		enableEncryption, err := parseOptionalBool(payload.DriverOpts["encryption"])
+		config, err := volume.ConfigFromDriverOpts(payload.DriverOpts, payload.Labels)
 		if err != nil {
-			handleError(w, fmt.Sprintf("Invalid encryption value: %v", err), http.StatusBadRequest)
+			handleError(w, fmt.Sprintf("Invalid DriverOpts: %v", err), http.StatusBadRequest)
 			return
 		}
-
-		optimization := payload.DriverOpts["optimization"]
-		if optimization == "" {
-			optimization = "standard"
+		if !authorizeLabels(w, r, payload.Name.String(), config.Labels) {
+			return
 		}
 
-		config := volume.VolumeConfig{
-			Size:             size,
-			EnableEncryption: enableEncryption,
-			EncryptionKey:    payload.DriverOpts["encryption_key"],
-			Optimization:     optimization,
-			Labels:           payload.Labels,
+		run := createVolumeJob(baseDir, payload.Name, config)
+
+		if isAsync(r) {
+			submitJob(w, r, jobManager, "create", payload.Name.String(), run)
+			return
 		}
 
-		volName, err := volume.CreateVolume(payload.Name, baseDir, config)
+		response, err := run(nil)
 		if err != nil {
-			handleError(w, fmt.Sprintf("Failed to create volume: %v", err), http.StatusInternalServerError)
+			handleVolumeError(w, "Failed to create volume", err)
 			return
 		}
 
-		log.Printf("Volume %s created successfully!", volName)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{
-			"status": "success",
-			"name":   volName,
-		})
-	}
-}
-
-func parseOptionalBool(raw string) (bool, error) {
-	if strings.TrimSpace(raw) == "" {
-		return false, nil
-	}
-
-	parsed, err := strconv.ParseBool(strings.TrimSpace(raw))
-	if err != nil {
-		return false, fmt.Errorf("expected one of true/false/1/0")
+		json.NewEncoder(w).Encode(response)
 	}
-
-	return parsed, nil
 }
 
-func DeleteVolumeHandler(baseDir string) http.HandlerFunc {
+func DeleteVolumeHandler(baseDir string, jobManager *jobs.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var payload DockerVolumePayload
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -168,23 +169,30 @@ func DeleteVolumeHandler(baseDir string) http.HandlerFunc {
 		defer r.Body.Close()
 
 		log.Printf("Received request to delete volume: %s", payload.Name)
+		if !authorizeVolume(w, r, baseDir, payload.Name) {
+			return
+		}
+
+		run := deleteVolumeJob(baseDir, payload.Name, deleteSnapshots(r))
 
-		if err := volume.DeleteVolume(payload.Name, baseDir); err != nil {
-			handleError(w, fmt.Sprintf("Failed to delete volume: %v", err), http.StatusInternalServerError)
+		if isAsync(r) {
+			submitJob(w, r, jobManager, "delete", payload.Name.String(), run)
+			return
+		}
+
+		response, err := run(nil)
+		if err != nil {
+			handleVolumeError(w, "Failed to delete volume", err)
 			return
 		}
 
-		log.Printf("Volume %s deleted successfully!", payload.Name)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{
-			"status": "success",
-			"name":   payload.Name,
-		})
+		json.NewEncoder(w).Encode(response)
 	}
 }
 
-func ResizeVolumeHandler(baseDir string) http.HandlerFunc {
+func ResizeVolumeHandler(baseDir string, jobManager *jobs.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var payload DockerVolumePayload
 		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
@@ -193,37 +201,27 @@ func ResizeVolumeHandler(baseDir string) http.HandlerFunc {
 		}
 		defer r.Body.Close()
 
-		newSize := strings.TrimSpace(payload.DriverOpts["size"])
-		if newSize == "" {
-			handleError(w, "DriverOpts.size is required", http.StatusBadRequest)
+		newSize, shrink, ok := resizeOptions(w, payload.DriverOpts)
+		if !ok {
 			return
 		}
 
-		log.Printf("Received request to resize volume: %s to %s", payload.Name, newSize)
-
-		previousBytes, updatedBytes, err := volume.ResizeVolume(payload.Name, baseDir, newSize)
-		if err != nil {
-			statusCode := http.StatusInternalServerError
-			if volume.IsValidationError(err) {
-				statusCode = http.StatusBadRequest
-			}
-			handleError(w, fmt.Sprintf("Failed to resize volume: %v", err), statusCode)
+		log.Printf("Received request to resize volume: %s to %s (shrink=%t)", payload.Name, newSize, shrink)
+		if !authorizeVolume(w, r, baseDir, payload.Name) {
 			return
 		}
 
-		stats, statsErr := volume.GetVolumeStats(payload.Name, baseDir)
-		if statsErr != nil {
-			log.Printf("warning: resized volume %s but failed to read stats: %v", payload.Name, statsErr)
-		}
+		run := resizeVolumeJob(baseDir, payload.Name, newSize, shrink, payload.DriverOpts["encryption_key"])
 
-		response := map[string]interface{}{
-			"status":              "success",
-			"name":                payload.Name,
-			"previous_size_bytes": previousBytes,
-			"new_size_bytes":      updatedBytes,
+		if isAsync(r) {
+			submitJob(w, r, jobManager, "resize", payload.Name.String(), run)
+			return
 		}
-		if stats != nil {
-			response["stats"] = stats
+
+		response, err := run(nil)
+		if err != nil {
+			handleVolumeError(w, "Failed to resize volume", err)
+			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -232,16 +230,92 @@ func ResizeVolumeHandler(baseDir string) http.HandlerFunc {
 	}
 }
 
+func createVolumeJob(baseDir string, name volume.VolumeName, config volume.VolumeConfig) jobs.Func {
+	return func(progress func(string)) (interface{}, error) {
+		volName, err := volume.CreateVolume(name, baseDir, config, volume.WithProgress(progress))
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Volume %s created successfully!", volName)
+		return &VolumeResponse{Status: "success", Name: volName}, nil
+	}
+}
+
+func deleteVolumeJob(baseDir string, name volume.VolumeName, withSnapshots bool) jobs.Func {
+	return func(progress func(string)) (interface{}, error) {
+		opts := []volume.Option{volume.WithProgress(progress)}
+		if withSnapshots {
+			opts = append(opts, volume.WithSnapshots())
+		}
+		if err := volume.DeleteVolume(name, baseDir, opts...); err != nil {
+			return nil, err
+		}
+		log.Printf("Volume %s deleted successfully!", name)
+		return &VolumeResponse{Status: "success", Name: name.String()}, nil
+	}
+}
+
+// resizeOptions reads the target size and shrink flag from DriverOpts. It
+// writes a 400 and returns false when either is invalid.
+func resizeOptions(w http.ResponseWriter, opts map[string]string) (string, bool, bool) {
+	newSize := strings.TrimSpace(opts["size"])
+	if newSize == "" {
+		handleError(w, "DriverOpts.size is required", http.StatusBadRequest)
+		return "", false, false
+	}
+
+	shrink := false
+	if raw := strings.TrimSpace(opts["shrink"]); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			handleError(w, "Invalid shrink value: expected one of true/false/1/0", http.StatusBadRequest)
+			return "", false, false
+		}
+		shrink = parsed
+	}
+	return newSize, shrink, true
+}
+
+func resizeVolumeJob(baseDir string, name volume.VolumeName, newSize string, shrink bool, encryptionKey string) jobs.Func {
+	return func(progress func(string)) (interface{}, error) {
+		var previousBytes, updatedBytes int64
+		var err error
+		if shrink {
+			previousBytes, updatedBytes, err = volume.ShrinkVolume(name, baseDir, newSize, encryptionKey, volume.WithProgress(progress))
+		} else {
+			previousBytes, updatedBytes, err = volume.ResizeVolume(name, baseDir, newSize, volume.WithProgress(progress))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		stats, statsErr := volume.GetVolumeStats(name.String(), baseDir)
+		if statsErr != nil {
+			log.Printf("warning: resized volume %s but failed to read stats: %v", name, statsErr)
+		}
+
+		return &ResizeResponse{
+			Status:            "success",
+			Name:              name.String(),
+			RequestedSize:     newSize,
+			PreviousSizeBytes: previousBytes,
+			NewSizeBytes:      updatedBytes,
+			Stats:             stats,
+		}, nil
+	}
+}
+
 func HealthCheckHandler(storageVersion string, getFileBrowserHealth func() FileBrowserHealth) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		response := map[string]interface{}{
-			"status":  "healthy",
-			"service": "hubfly-storage",
-			"version": storageVersion,
+		response := HealthResponse{
+			Status:  "healthy",
+			Service: "hubfly-storage",
+			Version: storageVersion,
 		}
 
 		if getFileBrowserHealth != nil {
-			response["filebrowser"] = getFileBrowserHealth()
+			fbHealth := getFileBrowserHealth()
+			response.FileBrowser = &fbHealth
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -260,10 +334,13 @@ func GetVolumeStatsHandler(baseDir string) http.HandlerFunc {
 		defer r.Body.Close()
 
 		log.Printf("Received request for volume stats: %s", payload.Name)
+		if !authorizeVolume(w, r, baseDir, payload.Name) {
+			return
+		}
 
-		stats, err := volume.GetVolumeStats(payload.Name, baseDir)
+		stats, err := volume.GetVolumeStats(payload.Name.String(), baseDir)
 		if err != nil {
-			handleError(w, fmt.Sprintf("Failed to get volume stats: %v", err), http.StatusInternalServerError)
+			handleVolumeError(w, "Failed to get volume stats", err)
 			return
 		}
 
@@ -284,6 +361,17 @@ func GetVolumesHandler(baseDir string) http.HandlerFunc {
 			return
 		}
 
+		principal, _ := auth.PrincipalFromContext(r.Context())
+		if principal.Restricted() {
+			visible := make([]*volume.VolumeStats, 0, len(volumes))
+			for _, stats := range volumes {
+				if stats.Metadata != nil && principal.CanAccess(stats.Metadata.Labels) {
+					visible = append(visible, stats)
+				}
+			}
+			volumes = visible
+		}
+
 		log.Printf("Volumes retrieved successfully!")
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -305,12 +393,19 @@ func URLVolumeCreateHandler(baseDir, fileBrowserBinaryPath string) http.HandlerF
 		}
 		defer r.Body.Close()
 
-		filebrowserURL := os.Getenv("FILEBROWSER_URL")
-		adminUser := os.Getenv("FILEBROWSER_ADMIN_USER")
-		adminPass := os.Getenv("FILEBROWSER_ADMIN_PASS")
+		if !authorizeVolume(w, r, baseDir, req.Name) {
+			return
+		}
+		if _, err := volume.GetVolumeMetadata(req.Name, baseDir); err != nil {
+			handleVolumeError(w, "Failed to share volume", err)
+			return
+		}
+
+		settings := filebrowser.CurrentSettings()
+		filebrowserURL := settings.URL
 
 		// Step 1: Admin Login
-		adminToken, err := loginFileBrowser(filebrowserURL, adminUser, adminPass)
+		adminToken, err := loginFileBrowser(filebrowserURL, settings.AdminUser, settings.AdminPass)
 		if err != nil {
 			handleError(w, fmt.Sprintf("Failed to login as admin: %v", err), http.StatusInternalServerError)
 			return
@@ -349,7 +444,7 @@ func URLVolumeCreateHandler(baseDir, fileBrowserBinaryPath string) http.HandlerF
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"url": fullURL})
+		json.NewEncoder(w).Encode(URLVolumeCreateResponse{URL: fullURL})
 	}
 }
 
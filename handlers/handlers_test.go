@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hubfly-storage/apierror"
+	"hubfly-storage/volume"
+)
+
+func TestCreateVolumeRejectsUnknownOptimization(t *testing.T) {
+	body := `{"Name":"data","DriverOpts":{"size":"1G","optimization":"turbo"}}`
+	rec := httptest.NewRecorder()
+	CreateVolumeHandler(t.TempDir(), nil)(rec, httptest.NewRequest(http.MethodPost, "/create-volume", strings.NewReader(body)))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+	var response apierror.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body.String(), err)
+	}
+	if response.Error.Code != volume.CodeInvalidRequest || !strings.Contains(response.Error.Message, "turbo") {
+		t.Errorf("error = %+v, want %s naming the mode", response.Error, volume.CodeInvalidRequest)
+	}
+}
+
+// Snapshots live under the volume directory, so deleting a volume that has
+// any needs delete_snapshots.
+func TestDeleteVolumeKeepsVolumeWithSnapshots(t *testing.T) {
+	baseDir := t.TempDir()
+	snapshotDir := filepath.Join(baseDir, "data", "snapshots", "before-deploy")
+	if err := os.MkdirAll(snapshotDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"/delete-volume", "/delete-volume?delete_snapshots=false"} {
+		rec := httptest.NewRecorder()
+		DeleteVolumeHandler(baseDir, nil)(rec, httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"Name":"data"}`)))
+
+		var response apierror.ErrorResponse
+		json.Unmarshal(rec.Body.Bytes(), &response)
+		if rec.Code != http.StatusConflict || response.Error.Code != volume.CodeVolumeHasSnapshots {
+			t.Errorf("POST %s = %d %s, want 409 %s", target, rec.Code, rec.Body.String(), volume.CodeVolumeHasSnapshots)
+		}
+	}
+	if _, err := os.Stat(snapshotDir); err != nil {
+		t.Errorf("snapshot removed by a refused delete: %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"hubfly-storage/jobs"
+)
+
+// isAsync reports whether the caller asked for the operation to run as a
+// background job with ?async=true.
+func isAsync(r *http.Request) bool {
+	async, err := strconv.ParseBool(r.URL.Query().Get("async"))
+	return err == nil && async
+}
+
+// deleteSnapshots reports whether a delete may remove the volume's
+// snapshots along with it.
+func deleteSnapshots(r *http.Request) bool {
+	remove, err := strconv.ParseBool(r.URL.Query().Get("delete_snapshots"))
+	return err == nil && remove
+}
+
+func submitJob(w http.ResponseWriter, r *http.Request, jobManager *jobs.Manager, jobType, volumeName string, fn jobs.Func) {
+	job, err := jobManager.Submit(jobType, volumeName, principalName(r), principalTokenID(r), fn)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if err == jobs.ErrQueueFull {
+			statusCode = http.StatusServiceUnavailable
+		}
+		handleError(w, fmt.Sprintf("Failed to submit job: %v", err), statusCode)
+		return
+	}
+
+	log.Printf("Queued %s job %s for volume %s", jobType, job.ID, volumeName)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(JobAcceptedResponse{
+		Status: "accepted",
+		JobID:  job.ID,
+		Job:    job,
+	})
+}
+
+// GetJobHandler serves GET /jobs/{id}. Callers restricted by a label
+// selector see jobs submitted with their token and jobs on volumes they can
+// access.
+func GetJobHandler(baseDir string, jobManager *jobs.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			handleError(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+		job, ok := jobManager.Get(id)
+		if ok && !submittedByCaller(r, job.TokenID) && !canAccessVolume(r, baseDir, job.Volume) {
+			ok = false
+		}
+		if !ok {
+			handleError(w, fmt.Sprintf("Job '%s' not found", id), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(job)
+	}
+}
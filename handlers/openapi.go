@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"hubfly-storage/apierror"
+	"hubfly-storage/auth"
+	"hubfly-storage/openapi"
+)
+
+var (
+	asyncParameter = openapi.Parameter{
+		Name:        "async",
+		In:          "query",
+		Description: "Queue the operation as a background job and answer with 202 Accepted.",
+		Schema:      &openapi.Schema{Type: "boolean"},
+	}
+	deleteSnapshotsParameter = openapi.Parameter{
+		Name:        "delete_snapshots",
+		In:          "query",
+		Description: "Delete the volume's snapshots with it. Without it, a volume with snapshots is not deleted and the request fails with 409 and volume_has_snapshots.",
+		Schema:      &openapi.Schema{Type: "boolean"},
+	}
+	volumeNameParameter = openapi.Parameter{
+		Name:        "name",
+		In:          "path",
+		Description: "Volume name.",
+		Required:    true,
+		Schema:      &openapi.Schema{Type: "string"},
+	}
+
+	readErrors   = []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusInternalServerError}
+	changeErrors = []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusInternalServerError}
+	growErrors   = statuses(changeErrors, []int{http.StatusInsufficientStorage})
+	asyncErrors  = []int{http.StatusServiceUnavailable}
+)
+
+func statuses(lists ...[]int) []int {
+	var all []int
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+	return all
+}
+
+// OpenAPIDocument builds the OpenAPI document for the HTTP API.
+func OpenAPIDocument(version string) *openapi.Document {
+	builder := openapi.NewBuilder(openapi.Info{
+		Title:       "hubfly-storage",
+		Version:     version,
+		Description: "HTTP API for creating and managing Docker volumes backed by image files.",
+	}, apierror.ErrorResponse{})
+	builder.AddSecurityScheme("bearerToken", &openapi.SecurityScheme{
+		Type:        "http",
+		Scheme:      "bearer",
+		Description: "Token issued with `hubfly-storage token issue`.",
+	})
+	builder.AddSecurityScheme("hmacSignature", &openapi.SecurityScheme{
+		Type:        "apiKey",
+		In:          "header",
+		Name:        auth.HeaderSignature,
+		Description: "HMAC-SHA256 request signature, sent with the timestamp and nonce headers.",
+	})
+
+	for _, route := range Routes(Deps{Version: version}) {
+		builder.Add(route.Route)
+	}
+	return builder.Document()
+}
+
+// OpenAPIHandler serves GET /openapi.json. The document is built on first
+// use, because building it lists the routes and so calls OpenAPIHandler.
+func OpenAPIHandler(version string) http.HandlerFunc {
+	var once sync.Once
+	var document []byte
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			handleError(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+
+		once.Do(func() {
+			var err error
+			if document, err = json.MarshalIndent(OpenAPIDocument(version), "", "  "); err != nil {
+				panic(err)
+			}
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(document)
+	}
+}
@@ -0,0 +1,370 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"hubfly-storage/audit"
+	"hubfly-storage/jobs"
+	"hubfly-storage/openapi"
+	"hubfly-storage/volume"
+)
+
+// Volumes used by the conformance test. Requests that would change a volume
+// through sudo or Docker target the missing one, or fail validation first.
+const (
+	existingVolume = "openapi-data"
+	missingVolume  = "openapi-missing"
+)
+
+type routeCase struct {
+	operationID string
+	query       string
+	body        string
+}
+
+var routeCases = []routeCase{
+	{operationID: "health"},
+	{operationID: "openapi"},
+	{operationID: "createVolume", body: `{"Name":"openapi-missing","DriverOpts":{"size":"2G"}}`},
+	{operationID: "createVolume", body: `{"Name":"../etc"}`},
+	{operationID: "deleteVolume", body: `{"Name":"openapi-missing"}`},
+	{operationID: "resizeVolume", body: `{"Name":"openapi-missing","DriverOpts":{"size":"2G"}}`},
+	{operationID: "getVolumeStats", body: `{"Name":"openapi-data"}`},
+	{operationID: "getVolumeStats", body: `{"Name":"openapi-missing"}`},
+	{operationID: "listVolumesV1"},
+	{operationID: "setAutogrow", body: `{"Name":"openapi-data","DriverOpts":{"autogrow_threshold":"80","autogrow_step":"1G","autogrow_max":"10G"}}`},
+	{operationID: "setAutogrow", body: `{"Name":"openapi-data","DriverOpts":{"autogrow":"false"}}`},
+	{operationID: "listAutogrowEvents"},
+	{operationID: "createSnapshot", body: `{"Name":"openapi-missing","Snapshot":"s1"}`},
+	{operationID: "createSnapshot", query: "async=true", body: `{"Name":"openapi-missing","Snapshot":"s1"}`},
+	{operationID: "listSnapshots", body: `{"Name":"openapi-data"}`},
+	{operationID: "deleteSnapshot", body: `{"Name":"openapi-data","Snapshot":"missing"}`},
+	{operationID: "restoreSnapshot", body: `{"Name":"openapi-missing","Snapshot":"s1"}`},
+	{operationID: "cloneSnapshot", body: `{"Name":"openapi-missing","Snapshot":"s1","Target":"openapi-clone"}`},
+	{operationID: "queryAudit"},
+	{operationID: "queryAudit", query: "since=yesterday"},
+	{operationID: "getJob"},
+	{operationID: "shareVolume", body: `{"name":"openapi-missing"}`},
+	{operationID: "listVolumes"},
+	{operationID: "getVolume"},
+	{operationID: "putVolume", body: `{"DriverOpts":{"size":"2G"}}`},
+	{operationID: "patchVolume", body: `{"Labels":{"team":"storage"}}`},
+	{operationID: "removeVolume"},
+	{operationID: "resizeVolumeV2", body: `{"DriverOpts":{"size":"+1G"}}`},
+}
+
+// TestRoutesMatchOpenAPIDocument serves every route through the mux and
+// checks that the status is documented for it and that the body matches
+// the documented schema.
+func TestRoutesMatchOpenAPIDocument(t *testing.T) {
+	baseDir := t.TempDir()
+	writeTestVolume(t, baseDir, existingVolume)
+
+	previousLimits := volume.CurrentLimits()
+	limits, err := volume.ParseLimits("1M", "", "1M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	volume.SetLimits(limits)
+	defer volume.SetLimits(previousLimits)
+
+	// Queued jobs write to baseDir, so the test waits for them before the
+	// directory is removed.
+	finished := make(chan jobs.Job, len(routeCases)+1)
+	jobManager, err := jobs.NewManager(filepath.Join(baseDir, ".jobs"), 1, func(job jobs.Job) {
+		finished <- job
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := finishedJob(t, jobManager)
+	queued := 1
+	auditLog, err := audit.Open(filepath.Join(baseDir, ".audit", "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes := Routes(Deps{
+		BaseDir:  baseDir,
+		Version:  "test",
+		Jobs:     jobManager,
+		AuditLog: auditLog,
+		Autogrow: volume.NewAutogrowWatcher(baseDir, time.Hour, nil),
+		FileBrowserHealth: func() FileBrowserHealth {
+			return FileBrowserHealth{Running: false}
+		},
+	})
+	mux := NewMux(routes, func(route Route) http.HandlerFunc {
+		if route.Audit != "" {
+			return audit.Record(auditLog, route.Audit, route.Handler)
+		}
+		return route.Handler
+	})
+	doc := OpenAPIDocument("test")
+
+	byOperation := map[string]Route{}
+	for _, route := range routes {
+		byOperation[route.OperationID] = route
+	}
+	tested := map[string]bool{}
+
+	for _, c := range routeCases {
+		route, ok := byOperation[c.operationID]
+		if !ok {
+			t.Errorf("no route with operation %s", c.operationID)
+			continue
+		}
+		tested[c.operationID] = true
+
+		name := existingVolume
+		if route.Method == http.MethodPut || route.Method == http.MethodDelete || strings.HasSuffix(route.Path, ":resize") {
+			name = missingVolume
+		}
+		target := strings.NewReplacer("{name}", name, "{id}", job.ID).Replace(route.Path)
+		if c.query != "" {
+			target += "?" + c.query
+		}
+		req := httptest.NewRequest(route.Method, target, strings.NewReader(c.body))
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if err := checkResponse(doc, route, rec); err != nil {
+			t.Errorf("%s %s: %v", route.Method, target, err)
+		}
+		if rec.Code == http.StatusAccepted {
+			queued++
+		}
+	}
+	for ; queued > 0; queued-- {
+		select {
+		case <-finished:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d queued jobs did not finish", queued)
+		}
+	}
+
+	for _, route := range routes {
+		if !tested[route.OperationID] {
+			t.Errorf("route %s %s (%s) has no case in routeCases", route.Method, route.Path, route.OperationID)
+		}
+	}
+}
+
+// TestMuxServesDocumentedMethods checks that v2 paths reject other methods
+// with an Allow header naming exactly the documented ones, while v1 paths
+// hand every method to their handler as they always have.
+func TestMuxServesDocumentedMethods(t *testing.T) {
+	mux := NewMux(Routes(Deps{BaseDir: t.TempDir()}), func(route Route) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Operation", route.OperationID)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	doc := OpenAPIDocument("test")
+
+	for path, item := range doc.Paths {
+		var methods []string
+		for method := range item {
+			methods = append(methods, strings.ToUpper(method))
+		}
+		sort.Strings(methods)
+
+		target := strings.NewReplacer("{name}", missingVolume, "{id}", "missing").Replace(path)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("TRACE", target, nil))
+
+		if !strings.HasPrefix(path, V2VolumesPath) {
+			if rec.Code != http.StatusNoContent || rec.Header().Get("X-Operation") == "" {
+				t.Errorf("TRACE %s = %d, want the v1 handler to serve it", target, rec.Code)
+			}
+			continue
+		}
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("TRACE %s = %d, want 405", target, rec.Code)
+			continue
+		}
+		if allow := rec.Header().Get("Allow"); allow != strings.Join(methods, ", ") {
+			t.Errorf("TRACE %s: Allow = %q, documented methods are %q", target, allow, strings.Join(methods, ", "))
+		}
+	}
+}
+
+func writeTestVolume(t *testing.T, baseDir, name string) {
+	t.Helper()
+	volumePath := filepath.Join(baseDir, name)
+	if err := os.MkdirAll(filepath.Join(volumePath, "_data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(volumePath, "volume.img"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := json.Marshal(volume.Metadata{
+		Name:         name,
+		SizeBytes:    1024 * 1024,
+		Optimization: volume.OptimizationStandard,
+		CreatedAt:    time.Now().UTC(),
+		UpdatedAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(volumePath, "volume.json"), meta, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func finishedJob(t *testing.T, jobManager *jobs.Manager) *jobs.Job {
+	t.Helper()
+	job, err := jobManager.Submit("snapshot", existingVolume, "", "", func(progress func(string)) (interface{}, error) {
+		progress("Copying image")
+		return SnapshotResponse{Status: "success"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if job, _ = jobManager.Get(job.ID); job.State == jobs.StateSucceeded {
+			return job
+		}
+	}
+	t.Fatalf("job %s did not finish: %+v", job.ID, job)
+	return nil
+}
+
+func checkResponse(doc *openapi.Document, route Route, rec *httptest.ResponseRecorder) error {
+	operation := doc.Paths[route.Path][strings.ToLower(route.Method)]
+	if operation == nil {
+		return fmt.Errorf("not in the OpenAPI document")
+	}
+	response, ok := operation.Responses[strconv.Itoa(rec.Code)]
+	if !ok {
+		return fmt.Errorf("status %d is not documented; body %s", rec.Code, rec.Body.String())
+	}
+	if response.Content == nil {
+		if rec.Body.Len() > 0 {
+			return fmt.Errorf("status %d is documented without a body, got %s", rec.Code, rec.Body.String())
+		}
+		return nil
+	}
+
+	if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		return fmt.Errorf("status %d: Content-Type %q, want application/json", rec.Code, contentType)
+	}
+	decoder := json.NewDecoder(rec.Body)
+	decoder.UseNumber()
+	var body interface{}
+	if err := decoder.Decode(&body); err != nil {
+		return fmt.Errorf("status %d: invalid JSON body: %v", rec.Code, err)
+	}
+	if err := conforms(doc, response.Content["application/json"].Schema, body, "body"); err != nil {
+		return fmt.Errorf("status %d: %v", rec.Code, err)
+	}
+	return nil
+}
+
+// conforms checks a decoded JSON value against the subset of JSON Schema
+// the openapi package emits. Objects with declared properties may not carry
+// undeclared ones.
+func conforms(doc *openapi.Document, schema *openapi.Schema, value interface{}, at string) error {
+	if schema.Ref != "" {
+		resolved, ok := doc.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", at, schema.Ref)
+		}
+		return conforms(doc, resolved, value, at)
+	}
+	if len(schema.AnyOf) > 0 {
+		var problems []string
+		for _, option := range schema.AnyOf {
+			err := conforms(doc, option, value, at)
+			if err == nil {
+				return nil
+			}
+			problems = append(problems, err.Error())
+		}
+		return fmt.Errorf("%s matches no alternative: %s", at, strings.Join(problems, "; "))
+	}
+
+	mismatch := func() error {
+		return fmt.Errorf("%s: %#v is not of type %s", at, value, schema.Type)
+	}
+	switch schema.Type {
+	case "":
+		return nil
+	case "null":
+		if value != nil {
+			return mismatch()
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return mismatch()
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return mismatch()
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				return fmt.Errorf("%s: %q is not a date-time", at, s)
+			}
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return mismatch()
+		}
+		if _, err := n.Int64(); err != nil {
+			return mismatch()
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return mismatch()
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for i, item := range items {
+			if err := conforms(doc, schema.Items, item, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for _, key := range schema.Required {
+			if _, ok := object[key]; !ok {
+				return fmt.Errorf("%s: missing required property %q", at, key)
+			}
+		}
+		for key, property := range object {
+			propertySchema, ok := schema.Properties[key]
+			if !ok {
+				propertySchema = schema.AdditionalProperties
+			}
+			if propertySchema == nil {
+				return fmt.Errorf("%s: undocumented property %q", at, key)
+			}
+			if err := conforms(doc, propertySchema, property, at+"."+key); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s: unsupported schema type %s", at, schema.Type)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"hubfly-storage/jobs"
+	"hubfly-storage/volume"
+)
+
+// Response bodies of the HTTP API. The OpenAPI document served at
+// /openapi.json is generated from these types, so a field added here shows
+// up in the schema without further changes.
+
+// VolumeResponse is returned by create and delete.
+type VolumeResponse struct {
+	Status string `json:"status"`
+	Name   string `json:"name"`
+}
+
+type ResizeResponse struct {
+	Status            string              `json:"status"`
+	Name              string              `json:"name"`
+	RequestedSize     string              `json:"requested_size"`
+	PreviousSizeBytes int64               `json:"previous_size_bytes"`
+	NewSizeBytes      int64               `json:"new_size_bytes"`
+	Stats             *volume.VolumeStats `json:"stats,omitempty"`
+}
+
+type HealthResponse struct {
+	Status      string             `json:"status"`
+	Service     string             `json:"service"`
+	Version     string             `json:"version"`
+	FileBrowser *FileBrowserHealth `json:"filebrowser,omitempty"`
+}
+
+type AutogrowResponse struct {
+	Status   string                 `json:"status"`
+	Name     string                 `json:"name"`
+	Autogrow *volume.AutogrowPolicy `json:"autogrow"`
+}
+
+// SnapshotResponse is returned by delete and restore snapshot.
+type SnapshotResponse struct {
+	Status   string `json:"status"`
+	Name     string `json:"name"`
+	Snapshot string `json:"snapshot"`
+}
+
+type CloneResponse struct {
+	Status   string           `json:"status"`
+	Name     string           `json:"name"`
+	Metadata *volume.Metadata `json:"metadata"`
+}
+
+// JobAcceptedResponse is returned with 202 Accepted for ?async=true calls.
+type JobAcceptedResponse struct {
+	Status string    `json:"status"`
+	JobID  string    `json:"job_id"`
+	Job    *jobs.Job `json:"job"`
+}
+
+type URLVolumeCreateResponse struct {
+	URL string `json:"url"`
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"hubfly-storage/audit"
+	"hubfly-storage/auth"
+	"hubfly-storage/jobs"
+	"hubfly-storage/openapi"
+	"hubfly-storage/volume"
+)
+
+// Route is one operation of the HTTP API: its OpenAPI description, the audit
+// operation it is recorded as, if any, and its handler. The server's mux and
+// the OpenAPI document are both built from Routes, so neither can list a
+// route the other lacks.
+type Route struct {
+	openapi.Route
+	Audit   string
+	Handler http.HandlerFunc
+}
+
+// Deps are the services the handlers use. The zero value is enough to
+// describe the routes without serving them.
+type Deps struct {
+	BaseDir           string
+	Version           string
+	Jobs              *jobs.Manager
+	AuditLog          *audit.Log
+	Autogrow          *volume.AutogrowWatcher
+	FileBrowserBinary string
+	FileBrowserHealth func() FileBrowserHealth
+}
+
+// Routes lists every route the server registers. Request and response values
+// are the types the handlers decode and encode.
+func Routes(deps Deps) []Route {
+	withAsync := func(responses map[int]interface{}) map[int]interface{} {
+		responses[http.StatusAccepted] = JobAcceptedResponse{}
+		return responses
+	}
+
+	return []Route{
+		{
+			Route: openapi.Route{
+				Method: http.MethodGet, Path: "/health", OperationID: "health", Tag: "service",
+				Summary:   "Service health and FileBrowser status",
+				Responses: map[int]interface{}{http.StatusOK: HealthResponse{}},
+			},
+			Handler: HealthCheckHandler(deps.Version, deps.FileBrowserHealth),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodGet, Path: "/openapi.json", OperationID: "openapi", Tag: "service",
+				Summary:   "This document",
+				Responses: map[int]interface{}{http.StatusOK: map[string]interface{}{}},
+			},
+			Handler: OpenAPIHandler(deps.Version),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPost, Path: "/create-volume", OperationID: "createVolume", Tag: "volumes",
+				Summary: "Create a volume", Scope: string(auth.ScopeVolumesWrite),
+				Parameters: []openapi.Parameter{asyncParameter},
+				Request:    DockerVolumePayload{},
+				Responses:  withAsync(map[int]interface{}{http.StatusOK: VolumeResponse{}}),
+				Errors:     statuses(growErrors, asyncErrors),
+			},
+			Audit:   "create",
+			Handler: CreateVolumeHandler(deps.BaseDir, deps.Jobs),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPost, Path: "/delete-volume", OperationID: "deleteVolume", Tag: "volumes",
+				Summary: "Delete a volume", Scope: string(auth.ScopeVolumesDelete),
+				Parameters: []openapi.Parameter{asyncParameter, deleteSnapshotsParameter},
+				Request:    DockerVolumePayload{},
+				Responses:  withAsync(map[int]interface{}{http.StatusOK: VolumeResponse{}}),
+				Errors:     statuses(changeErrors, asyncErrors),
+			},
+			Audit:   "delete",
+			Handler: DeleteVolumeHandler(deps.BaseDir, deps.Jobs),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPost, Path: "/resize-volume", OperationID: "resizeVolume", Tag: "volumes",
+				Summary: "Grow or shrink a volume", Scope: string(auth.ScopeVolumesWrite),
+				Parameters: []openapi.Parameter{asyncParameter},
+				Request:    DockerVolumePayload{},
+				Responses:  withAsync(map[int]interface{}{http.StatusOK: ResizeResponse{}}),
+				Errors:     statuses(growErrors, asyncErrors),
+			},
+			Audit:   "resize",
+			Handler: ResizeVolumeHandler(deps.BaseDir, deps.Jobs),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPost, Path: "/volume-stats", OperationID: "getVolumeStats", Tag: "volumes",
+				Summary: "Usage statistics of a volume", Scope: string(auth.ScopeVolumesRead),
+				Request:   DockerVolumePayload{},
+				Responses: map[int]interface{}{http.StatusOK: volume.VolumeStats{}},
+				Errors:    readErrors,
+			},
+			Handler: GetVolumeStatsHandler(deps.BaseDir),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodGet, Path: "/dev/volumes", OperationID: "listVolumesV1", Tag: "volumes",
+				Summary: "Statistics of every volume", Scope: string(auth.ScopeVolumesRead),
+				Responses: map[int]interface{}{http.StatusOK: []volume.VolumeStats{}},
+				Errors:    readErrors,
+			},
+			Handler: GetVolumesHandler(deps.BaseDir),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPost, Path: "/autogrow-volume", OperationID: "setAutogrow", Tag: "volumes",
+				Summary: "Set or clear the autogrow policy of a volume", Scope: string(auth.ScopeVolumesWrite),
+				Request:   DockerVolumePayload{},
+				Responses: map[int]interface{}{http.StatusOK: AutogrowResponse{}},
+				Errors:    changeErrors,
+			},
+			Audit:   "autogrow",
+			Handler: SetAutogrowHandler(deps.BaseDir),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodGet, Path: "/autogrow-events", OperationID: "listAutogrowEvents", Tag: "volumes",
+				Summary: "Recent automatic resizes", Scope: string(auth.ScopeVolumesRead),
+				Responses: map[int]interface{}{http.StatusOK: []volume.AutogrowEvent{}},
+				Errors:    readErrors,
+			},
+			Handler: GetAutogrowEventsHandler(deps.BaseDir, deps.Autogrow),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPost, Path: "/create-snapshot", OperationID: "createSnapshot", Tag: "snapshots",
+				Summary: "Snapshot a volume", Scope: string(auth.ScopeVolumesWrite),
+				Parameters: []openapi.Parameter{asyncParameter},
+				Request:    SnapshotPayload{},
+				Responses:  withAsync(map[int]interface{}{http.StatusOK: volume.Snapshot{}}),
+				Errors:     statuses(growErrors, asyncErrors),
+			},
+			Audit:   "snapshot",
+			Handler: CreateSnapshotHandler(deps.BaseDir, deps.Jobs),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPost, Path: "/list-snapshots", OperationID: "listSnapshots", Tag: "snapshots",
+				Summary: "Snapshots of a volume", Scope: string(auth.ScopeVolumesRead),
+				Request:   SnapshotPayload{},
+				Responses: map[int]interface{}{http.StatusOK: []volume.Snapshot{}},
+				Errors:    readErrors,
+			},
+			Handler: ListSnapshotsHandler(deps.BaseDir),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPost, Path: "/delete-snapshot", OperationID: "deleteSnapshot", Tag: "snapshots",
+				Summary: "Delete a snapshot", Scope: string(auth.ScopeVolumesDelete),
+				Request:   SnapshotPayload{},
+				Responses: map[int]interface{}{http.StatusOK: SnapshotResponse{}},
+				Errors:    changeErrors,
+			},
+			Audit:   "delete_snapshot",
+			Handler: DeleteSnapshotHandler(deps.BaseDir),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPost, Path: "/restore-snapshot", OperationID: "restoreSnapshot", Tag: "snapshots",
+				Summary: "Roll a volume back to a snapshot", Scope: string(auth.ScopeVolumesWrite),
+				Request:   SnapshotPayload{},
+				Responses: map[int]interface{}{http.StatusOK: SnapshotResponse{}},
+				Errors:    changeErrors,
+			},
+			Audit:   "restore",
+			Handler: RestoreSnapshotHandler(deps.BaseDir),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPost, Path: "/clone-snapshot", OperationID: "cloneSnapshot", Tag: "snapshots",
+				Summary: "Create a volume from a snapshot", Scope: string(auth.ScopeVolumesWrite),
+				Request:   SnapshotPayload{},
+				Responses: map[int]interface{}{http.StatusOK: CloneResponse{}},
+				Errors:    growErrors,
+			},
+			Audit:   "clone",
+			Handler: CloneSnapshotHandler(deps.BaseDir),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodGet, Path: "/audit", OperationID: "queryAudit", Tag: "service",
+				Summary: "Audit log entries", Scope: string(auth.ScopeVolumesRead),
+				Parameters: []openapi.Parameter{
+					{Name: "volume", In: "query", Schema: &openapi.Schema{Type: "string"}},
+					{Name: "since", In: "query", Description: "RFC 3339 timestamp or a duration before now, such as 24h.", Schema: &openapi.Schema{Type: "string"}},
+					{Name: "until", In: "query", Description: "RFC 3339 timestamp or a duration before now.", Schema: &openapi.Schema{Type: "string"}},
+				},
+				Responses: map[int]interface{}{http.StatusOK: []audit.Entry{}},
+				Errors:    []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusForbidden, http.StatusMethodNotAllowed, http.StatusInternalServerError},
+			},
+			Handler: GetAuditHandler(deps.BaseDir, deps.AuditLog),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodGet, Path: "/jobs/{id}", OperationID: "getJob", Tag: "service",
+				Summary: "Progress of a background job", Scope: string(auth.ScopeVolumesRead),
+				Parameters: []openapi.Parameter{{Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}},
+				Responses:  map[int]interface{}{http.StatusOK: jobs.Job{}},
+				Errors:     []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusMethodNotAllowed},
+			},
+			Handler: GetJobHandler(deps.BaseDir, deps.Jobs),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPost, Path: "/url-volume/create", OperationID: "shareVolume", Tag: "filebrowser",
+				Summary: "Temporary FileBrowser login URL for a volume", Scope: string(auth.ScopeFileBrowserShare),
+				Request:   URLVolumeCreateRequest{},
+				Responses: map[int]interface{}{http.StatusOK: URLVolumeCreateResponse{}},
+				Errors:    statuses(readErrors, []int{http.StatusMethodNotAllowed}),
+			},
+			Audit:   "share",
+			Handler: URLVolumeCreateHandler(deps.BaseDir, deps.FileBrowserBinary),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodGet, Path: V2VolumesPath, OperationID: "listVolumes", Tag: "v2",
+				Summary: "Statistics of every volume", Scope: string(auth.ScopeVolumesRead),
+				Responses: map[int]interface{}{http.StatusOK: []volume.VolumeStats{}},
+				Errors:    []int{http.StatusUnauthorized, http.StatusForbidden, http.StatusMethodNotAllowed, http.StatusInternalServerError},
+			},
+			Handler: GetVolumesHandler(deps.BaseDir),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodGet, Path: V2VolumesPath + "/{name}", OperationID: "getVolume", Tag: "v2",
+				Summary: "Usage statistics of a volume", Scope: string(auth.ScopeVolumesRead),
+				Parameters: []openapi.Parameter{volumeNameParameter},
+				Responses:  map[int]interface{}{http.StatusOK: volume.VolumeStats{}},
+				Errors:     readErrors,
+			},
+			Handler: VolumeGetHandler(deps.BaseDir),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPut, Path: V2VolumesPath + "/{name}", OperationID: "putVolume", Tag: "v2",
+				Summary: "Create a volume", Scope: string(auth.ScopeVolumesWrite),
+				Parameters: []openapi.Parameter{volumeNameParameter, asyncParameter},
+				Request:    DockerVolumePayload{},
+				Responses:  withAsync(map[int]interface{}{http.StatusCreated: VolumeResponse{}}),
+				Errors:     statuses(growErrors, asyncErrors),
+			},
+			Audit:   "create",
+			Handler: VolumePutHandler(deps.BaseDir, deps.Jobs),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPatch, Path: V2VolumesPath + "/{name}", OperationID: "patchVolume", Tag: "v2",
+				Summary: "Change the labels or autogrow policy of a volume", Scope: string(auth.ScopeVolumesWrite),
+				Parameters: []openapi.Parameter{volumeNameParameter},
+				Request:    DockerVolumePayload{},
+				Responses:  map[int]interface{}{http.StatusOK: volume.Metadata{}},
+				Errors:     changeErrors,
+			},
+			Audit:   "update",
+			Handler: VolumePatchHandler(deps.BaseDir),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodDelete, Path: V2VolumesPath + "/{name}", OperationID: "removeVolume", Tag: "v2",
+				Summary: "Delete a volume", Scope: string(auth.ScopeVolumesDelete),
+				Parameters: []openapi.Parameter{volumeNameParameter, asyncParameter, deleteSnapshotsParameter},
+				Responses:  withAsync(map[int]interface{}{http.StatusOK: VolumeResponse{}}),
+				Errors:     statuses(changeErrors, asyncErrors),
+			},
+			Audit:   "delete",
+			Handler: VolumeDeleteHandler(deps.BaseDir, deps.Jobs),
+		},
+		{
+			Route: openapi.Route{
+				Method: http.MethodPost, Path: V2VolumesPath + "/{name}:resize", OperationID: "resizeVolumeV2", Tag: "v2",
+				Summary: "Grow or shrink a volume", Scope: string(auth.ScopeVolumesWrite),
+				Parameters: []openapi.Parameter{volumeNameParameter, asyncParameter},
+				Request:    DockerVolumePayload{},
+				Responses:  withAsync(map[int]interface{}{http.StatusOK: ResizeResponse{}}),
+				Errors:     statuses(growErrors, asyncErrors),
+			},
+			Audit:   "resize",
+			Handler: VolumeResizeHandler(deps.BaseDir, deps.Jobs),
+		},
+	}
+}
+
+// NewMux registers routes on a new ServeMux. wrap returns the handler to
+// serve for a route, typically its Handler behind authentication and
+// auditing; nil serves Handler as is. Other methods on a v2 path are
+// answered with 405. A v1 path serves its handler for any method, as it
+// always has, so each v1 path takes exactly one route.
+func NewMux(routes []Route, wrap func(Route) http.HandlerFunc) *http.ServeMux {
+	v2Volumes := VolumeRoutes{Collection: Methods{}, Volume: Methods{}, Resize: Methods{}}
+	mux := http.NewServeMux()
+	registered := map[string]bool{}
+
+	for _, route := range routes {
+		handler := route.Handler
+		if wrap != nil {
+			handler = wrap(route)
+		}
+
+		switch route.Path {
+		case V2VolumesPath:
+			v2Volumes.Collection[route.Method] = handler
+			continue
+		case V2VolumesPath + "/{name}":
+			v2Volumes.Volume[route.Method] = handler
+			continue
+		case V2VolumesPath + "/{name}:resize":
+			v2Volumes.Resize[route.Method] = handler
+			continue
+		}
+		if strings.HasPrefix(route.Path, V2VolumesPath) {
+			panic(fmt.Sprintf("handlers: VolumeRoutes cannot serve %s", route.Path))
+		}
+
+		// A path parameter matches the rest of the path, as in /jobs/{id}.
+		pattern := route.Path
+		if i := strings.Index(pattern, "{"); i >= 0 {
+			pattern = pattern[:i]
+		}
+		if registered[pattern] {
+			panic(fmt.Sprintf("handlers: v1 path %s has more than one route", route.Path))
+		}
+		registered[pattern] = true
+		mux.Handle(pattern, handler)
+	}
+
+	mux.Handle(V2VolumesPath, v2Volumes)
+	mux.Handle(V2VolumesPath+"/", v2Volumes)
+	return mux
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"hubfly-storage/jobs"
+	"hubfly-storage/volume"
+)
+
+type SnapshotPayload struct {
+	Name       volume.VolumeName `json:"Name"`
+	Snapshot   string            `json:"Snapshot"`
+	Target     volume.VolumeName `json:"Target,omitempty"`
+	DriverOpts map[string]string `json:"DriverOpts,omitempty"`
+	Labels     map[string]string `json:"Labels,omitempty"`
+}
+
+func CreateSnapshotHandler(baseDir string, jobManager *jobs.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload SnapshotPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			handleError(w, fmt.Sprintf("Invalid JSON payload: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		log.Printf("Received request to snapshot volume: %s", payload.Name)
+		if !authorizeVolume(w, r, baseDir, payload.Name) {
+			return
+		}
+
+		run := func(progress func(string)) (interface{}, error) {
+			snapshot, err := volume.CreateSnapshot(payload.Name, baseDir, payload.Snapshot, volume.WithProgress(progress))
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Snapshot %s of %s created successfully!", snapshot.Name, payload.Name)
+			return snapshot, nil
+		}
+
+		if isAsync(r) {
+			submitJob(w, r, jobManager, "snapshot", payload.Name.String(), run)
+			return
+		}
+
+		snapshot, err := run(nil)
+		if err != nil {
+			handleVolumeError(w, "Failed to create snapshot", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(snapshot)
+	}
+}
+
+func ListSnapshotsHandler(baseDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload SnapshotPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			handleError(w, fmt.Sprintf("Invalid JSON payload: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		if !authorizeVolume(w, r, baseDir, payload.Name) {
+			return
+		}
+
+		snapshots, err := volume.ListSnapshots(payload.Name, baseDir)
+		if err != nil {
+			handleVolumeError(w, "Failed to list snapshots", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(snapshots)
+	}
+}
+
+func DeleteSnapshotHandler(baseDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload SnapshotPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			handleError(w, fmt.Sprintf("Invalid JSON payload: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		log.Printf("Received request to delete snapshot %s of %s", payload.Snapshot, payload.Name)
+		if !authorizeVolume(w, r, baseDir, payload.Name) {
+			return
+		}
+
+		if err := volume.DeleteSnapshot(payload.Name, baseDir, payload.Snapshot); err != nil {
+			handleVolumeError(w, "Failed to delete snapshot", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SnapshotResponse{
+			Status:   "success",
+			Name:     payload.Name.String(),
+			Snapshot: payload.Snapshot,
+		})
+	}
+}
+
+func RestoreSnapshotHandler(baseDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload SnapshotPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			handleError(w, fmt.Sprintf("Invalid JSON payload: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		log.Printf("Received request to restore volume %s from snapshot %s", payload.Name, payload.Snapshot)
+		if !authorizeVolume(w, r, baseDir, payload.Name) {
+			return
+		}
+
+		if err := volume.RestoreSnapshot(payload.Name, baseDir, payload.Snapshot, payload.DriverOpts["encryption_key"]); err != nil {
+			handleVolumeError(w, "Failed to restore snapshot", err)
+			return
+		}
+
+		log.Printf("Volume %s restored from snapshot %s successfully!", payload.Name, payload.Snapshot)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(SnapshotResponse{
+			Status:   "success",
+			Name:     payload.Name.String(),
+			Snapshot: payload.Snapshot,
+		})
+	}
+}
+
+func CloneSnapshotHandler(baseDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var payload SnapshotPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			handleError(w, fmt.Sprintf("Invalid JSON payload: %v", err), http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		log.Printf("Received request to clone snapshot %s of %s into %s", payload.Snapshot, payload.Name, payload.Target)
+		if !authorizeVolume(w, r, baseDir, payload.Name) {
+			return
+		}
+		if !authorizeLabels(w, r, payload.Target.String(), payload.Labels) {
+			return
+		}
+
+		config := volume.VolumeConfig{
+			EncryptionKey: payload.DriverOpts["encryption_key"],
+			Optimization:  payload.DriverOpts["optimization"],
+			Labels:        payload.Labels,
+			Owner:         payload.DriverOpts["owner"],
+		}
+
+		meta, err := volume.CloneSnapshot(payload.Name, baseDir, payload.Snapshot, payload.Target, config)
+		if err != nil {
+			handleVolumeError(w, "Failed to clone snapshot", err)
+			return
+		}
+
+		log.Printf("Volume %s cloned from snapshot %s successfully!", payload.Target, payload.Snapshot)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(CloneResponse{
+			Status:   "success",
+			Name:     meta.Name,
+			Metadata: meta,
+		})
+	}
+}
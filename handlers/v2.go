@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"hubfly-storage/jobs"
+	"hubfly-storage/volume"
+)
+
+// V2VolumesPath is the root of the v2 volume resource API.
+const V2VolumesPath = "/v2/volumes"
+
+// Methods routes a request by HTTP method. Any other method is answered with
+// 405 and an Allow header listing the supported ones.
+type Methods map[string]http.HandlerFunc
+
+func (m Methods) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, ok := m[r.Method]; ok {
+		handler(w, r)
+		return
+	}
+
+	allowed := make([]string, 0, len(m))
+	for method := range m {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	handleError(w, fmt.Sprintf("Method %s is not allowed on %s; use %s", r.Method, r.URL.Path, strings.Join(allowed, ", ")), http.StatusMethodNotAllowed)
+}
+
+// VolumeRoutes serves the v2 volume resource API:
+//
+//	GET    /v2/volumes                 Collection
+//	GET    /v2/volumes/{name}          Volume
+//	PUT    /v2/volumes/{name}          Volume
+//	PATCH  /v2/volumes/{name}          Volume
+//	DELETE /v2/volumes/{name}          Volume
+//	POST   /v2/volumes/{name}:resize   Resize
+//
+// Register it for both V2VolumesPath and V2VolumesPath + "/".
+type VolumeRoutes struct {
+	Collection Methods
+	Volume     Methods
+	Resize     Methods
+}
+
+func (routes VolumeRoutes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, action := SplitVolumePath(r.URL.Path)
+	switch {
+	case name == "" && action == "":
+		routes.Collection.ServeHTTP(w, r)
+	case action == "":
+		routes.Volume.ServeHTTP(w, r)
+	case action == "resize":
+		routes.Resize.ServeHTTP(w, r)
+	default:
+		handleError(w, fmt.Sprintf("Unknown volume action '%s'", action), http.StatusNotFound)
+	}
+}
+
+// SplitVolumePath splits a v2 path such as /v2/volumes/data:resize into the
+// volume name and the action after the colon. Volume names cannot contain a
+// colon, so the split is unambiguous.
+func SplitVolumePath(path string) (string, string) {
+	if !strings.HasPrefix(path, V2VolumesPath) {
+		return "", ""
+	}
+	rest := strings.Trim(strings.TrimPrefix(path, V2VolumesPath), "/")
+	if i := strings.LastIndex(rest, ":"); i >= 0 {
+		return rest[:i], rest[i+1:]
+	}
+	return rest, ""
+}
+
+// pathVolumeName validates the volume name in a v2 path. It writes a 400 and
+// returns false when the name is invalid.
+func pathVolumeName(w http.ResponseWriter, r *http.Request) (volume.VolumeName, bool) {
+	raw, _ := SplitVolumePath(r.URL.Path)
+	name, err := volume.ParseVolumeName(raw)
+	if err != nil {
+		handleError(w, err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return name, true
+}
+
+// decodeVolumeBody reads an optional JSON body for a v2 volume request. A
+// Name in the body must match the name in the path. It writes a 400 and
+// returns false otherwise.
+func decodeVolumeBody(w http.ResponseWriter, r *http.Request, name volume.VolumeName, payload *DockerVolumePayload) bool {
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(payload); err != nil && err != io.EOF {
+		handleError(w, fmt.Sprintf("Invalid JSON payload: %v", err), http.StatusBadRequest)
+		return false
+	}
+	if payload.Name != "" && payload.Name != name {
+		handleError(w, fmt.Sprintf("Name '%s' in the body does not match volume '%s' in the path", payload.Name, name), http.StatusBadRequest)
+		return false
+	}
+	payload.Name = name
+	return true
+}
+
+// VolumeGetHandler serves GET /v2/volumes/{name} with the volume's stats.
+func VolumeGetHandler(baseDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, ok := pathVolumeName(w, r)
+		if !ok || !authorizeVolume(w, r, baseDir, name) {
+			return
+		}
+
+		stats, err := volume.GetVolumeStats(name.String(), baseDir)
+		if err != nil {
+			handleVolumeError(w, "Failed to get volume stats", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+// VolumePutHandler serves PUT /v2/volumes/{name}, creating the volume from
+// the DriverOpts and Labels in the body. An existing volume is not replaced.
+func VolumePutHandler(baseDir string, jobManager *jobs.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, ok := pathVolumeName(w, r)
+		if !ok {
+			return
+		}
+		var payload DockerVolumePayload
+		if !decodeVolumeBody(w, r, name, &payload) {
+			return
+		}
+
+		log.Printf("Received request to create volume: %s", name)
+
+		config, err := volume.ConfigFromDriverOpts(payload.DriverOpts, payload.Labels)
+		if err != nil {
+			handleError(w, fmt.Sprintf("Invalid DriverOpts: %v", err), http.StatusBadRequest)
+			return
+		}
+		if !authorizeLabels(w, r, name.String(), config.Labels) {
+			return
+		}
+
+		run := createVolumeJob(baseDir, name, config)
+		if isAsync(r) {
+			submitJob(w, r, jobManager, "create", name.String(), run)
+			return
+		}
+
+		response, err := run(nil)
+		if err != nil {
+			handleVolumeError(w, "Failed to create volume", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", V2VolumesPath+"/"+name.String())
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// VolumePatchHandler serves PATCH /v2/volumes/{name}. Labels, when present,
+// replace the stored labels; the autogrow driver options set or clear the
+// autogrow policy the same way /autogrow-volume does.
+func VolumePatchHandler(baseDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, ok := pathVolumeName(w, r)
+		if !ok {
+			return
+		}
+		var payload DockerVolumePayload
+		if !decodeVolumeBody(w, r, name, &payload) {
+			return
+		}
+
+		update := volume.MetadataUpdate{Labels: payload.Labels}
+		if hasAutogrowOptions(payload.DriverOpts) {
+			policy, enabled, ok := autogrowOptions(w, payload.DriverOpts)
+			if !ok {
+				return
+			}
+			update.Autogrow = policy
+			update.ClearAutogrow = !enabled
+		}
+
+		log.Printf("Received request to update volume: %s", name)
+		if !authorizeVolume(w, r, baseDir, name) {
+			return
+		}
+		if update.Labels != nil && !authorizeLabels(w, r, name.String(), update.Labels) {
+			return
+		}
+
+		meta, err := volume.UpdateMetadata(name, baseDir, update)
+		if err != nil {
+			handleVolumeError(w, "Failed to update volume", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(meta)
+	}
+}
+
+func hasAutogrowOptions(opts map[string]string) bool {
+	for key := range opts {
+		if strings.HasPrefix(key, "autogrow") {
+			return true
+		}
+	}
+	return false
+}
+
+// VolumeDeleteHandler serves DELETE /v2/volumes/{name}.
+func VolumeDeleteHandler(baseDir string, jobManager *jobs.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, ok := pathVolumeName(w, r)
+		if !ok {
+			return
+		}
+
+		log.Printf("Received request to delete volume: %s", name)
+		if !authorizeVolume(w, r, baseDir, name) {
+			return
+		}
+
+		run := deleteVolumeJob(baseDir, name, deleteSnapshots(r))
+		if isAsync(r) {
+			submitJob(w, r, jobManager, "delete", name.String(), run)
+			return
+		}
+
+		response, err := run(nil)
+		if err != nil {
+			handleVolumeError(w, "Failed to delete volume", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// VolumeResizeHandler serves POST /v2/volumes/{name}:resize with the same
+// DriverOpts as /resize-volume.
+func VolumeResizeHandler(baseDir string, jobManager *jobs.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, ok := pathVolumeName(w, r)
+		if !ok {
+			return
+		}
+		var payload DockerVolumePayload
+		if !decodeVolumeBody(w, r, name, &payload) {
+			return
+		}
+
+		newSize, shrink, ok := resizeOptions(w, payload.DriverOpts)
+		if !ok {
+			return
+		}
+
+		log.Printf("Received request to resize volume: %s to %s (shrink=%t)", name, newSize, shrink)
+		if !authorizeVolume(w, r, baseDir, name) {
+			return
+		}
+
+		run := resizeVolumeJob(baseDir, name, newSize, shrink, payload.DriverOpts["encryption_key"])
+		if isAsync(r) {
+			submitJob(w, r, jobManager, "resize", name.String(), run)
+			return
+		}
+
+		response, err := run(nil)
+		if err != nil {
+			handleVolumeError(w, "Failed to resize volume", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,324 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+)
+
+// retention is how long finished jobs are kept. Older jobs are dropped at
+// startup and whenever another job finishes.
+const retention = 7 * 24 * time.Hour
+
+const queueSize = 256
+
+var ErrQueueFull = errors.New("job queue is full")
+
+type Step struct {
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+type Job struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Volume     string          `json:"volume"`
+	Principal  string          `json:"principal,omitempty"`
+	TokenID    string          `json:"token_id,omitempty"`
+	State      State           `json:"state"`
+	Steps      []Step          `json:"steps"`
+	Error      string          `json:"error,omitempty"`
+	ErrorCode  string          `json:"error_code,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	StartedAt  *time.Time      `json:"started_at,omitempty"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Func is the work a job performs. It reports steps through progress and
+// returns a JSON-encodable result.
+type Func func(progress func(step string)) (interface{}, error)
+
+type task struct {
+	id string
+	fn Func
+}
+
+// Manager runs jobs on a fixed pool of workers and persists every state
+// change as <dir>/<id>.json so job status survives a restart.
+type Manager struct {
+	dir      string
+	queue    chan task
+	onFinish func(Job)
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewManager loads persisted jobs from dir and starts workers. Jobs that
+// were queued or running when the service stopped are marked failed,
+// because their work cannot be resumed safely. onFinish, if set, is called
+// with a copy of each job that finishes, including those marked failed here.
+func NewManager(dir string, workers int, onFinish func(Job)) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create jobs directory: %v", err)
+	}
+
+	m := &Manager{
+		dir:      dir,
+		queue:    make(chan task, queueSize),
+		onFinish: onFinish,
+		jobs:     make(map[string]*Job),
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		go m.worker()
+	}
+	return m, nil
+}
+
+// Submit queues fn and returns the new job immediately. principal and
+// tokenID identify the caller, or are empty for unauthenticated requests.
+func (m *Manager) Submit(jobType, volumeName, principal, tokenID string, fn Func) (*Job, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{
+		ID:        id,
+		Type:      jobType,
+		Volume:    volumeName,
+		Principal: principal,
+		TokenID:   tokenID,
+		State:     StateQueued,
+		Steps:     []Step{},
+		CreatedAt: time.Now().UTC(),
+	}
+
+	m.mu.Lock()
+	m.jobs[id] = job
+	err = m.persistLocked(job)
+	m.mu.Unlock()
+	if err != nil {
+		m.forget(id)
+		return nil, err
+	}
+
+	select {
+	case m.queue <- task{id: id, fn: fn}:
+	default:
+		m.forget(id)
+		return nil, ErrQueueFull
+	}
+
+	job, _ = m.Get(id)
+	return job, nil
+}
+
+// Get returns a copy of the job with the given ID.
+func (m *Manager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *job
+	copied.Steps = append([]Step{}, job.Steps...)
+	return &copied, true
+}
+
+func (m *Manager) worker() {
+	for t := range m.queue {
+		m.run(t)
+	}
+}
+
+func (m *Manager) run(t task) {
+	m.update(t.id, func(job *Job) {
+		now := time.Now().UTC()
+		job.State = StateRunning
+		job.StartedAt = &now
+	})
+
+	result, err := call(t.id, t.fn, func(step string) {
+		m.update(t.id, func(job *Job) {
+			job.Steps = append(job.Steps, Step{Message: step, Time: time.Now().UTC()})
+		})
+	})
+
+	var encoded json.RawMessage
+	if err == nil && result != nil {
+		var marshalErr error
+		encoded, marshalErr = json.Marshal(result)
+		if marshalErr != nil {
+			log.Printf("job %s: failed to encode result: %v", t.id, marshalErr)
+		}
+	}
+
+	m.update(t.id, func(job *Job) {
+		now := time.Now().UTC()
+		job.FinishedAt = &now
+		if err != nil {
+			job.State = StateFailed
+			job.Error = err.Error()
+			var coded interface{ ErrorCode() string }
+			if errors.As(err, &coded) {
+				job.ErrorCode = coded.ErrorCode()
+			}
+			return
+		}
+		job.State = StateSucceeded
+		job.Result = encoded
+	})
+
+	if m.onFinish != nil {
+		if job, ok := m.Get(t.id); ok {
+			m.onFinish(*job)
+		}
+	}
+	m.prune()
+}
+
+// call runs fn and turns a panic into an error, so the job is marked
+// failed and the worker keeps serving the queue.
+func call(id string, fn Func, progress func(step string)) (result interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("job %s: panic: %v\n%s", id, recovered, debug.Stack())
+			result, err = nil, fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+	return fn(progress)
+}
+
+// prune drops finished jobs older than retention from memory and disk.
+func (m *Manager) prune() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, job := range m.jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > retention {
+			delete(m.jobs, id)
+			_ = os.Remove(m.jobPath(id))
+		}
+	}
+}
+
+func (m *Manager) update(id string, fn func(job *Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return
+	}
+	fn(job)
+	if err := m.persistLocked(job); err != nil {
+		log.Printf("job %s: failed to persist state: %v", id, err)
+	}
+}
+
+func (m *Manager) forget(id string) {
+	m.mu.Lock()
+	delete(m.jobs, id)
+	m.mu.Unlock()
+	_ = os.Remove(m.jobPath(id))
+}
+
+func (m *Manager) load() error {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read jobs directory: %v", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(m.dir, entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("failed to read job %s: %v", path, err)
+			continue
+		}
+		var job Job
+		if err := json.Unmarshal(content, &job); err != nil {
+			log.Printf("failed to decode job %s: %v", path, err)
+			continue
+		}
+
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > retention {
+			_ = os.Remove(path)
+			continue
+		}
+
+		if job.State == StateQueued || job.State == StateRunning {
+			now := time.Now().UTC()
+			job.State = StateFailed
+			job.Error = "interrupted by service restart"
+			job.FinishedAt = &now
+			if err := m.persistLocked(&job); err != nil {
+				log.Printf("job %s: failed to persist state: %v", job.ID, err)
+			}
+			if m.onFinish != nil {
+				m.onFinish(job)
+			}
+		}
+
+		m.jobs[job.ID] = &job
+	}
+	return nil
+}
+
+func (m *Manager) jobPath(id string) string {
+	return filepath.Join(m.dir, id+".json")
+}
+
+func (m *Manager) persistLocked(job *Job) error {
+	content, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := m.jobPath(job.ID) + ".tmp"
+	if err := os.WriteFile(tmpPath, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, m.jobPath(job.ID))
+}
+
+func newID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
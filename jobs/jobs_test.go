@@ -0,0 +1,183 @@
+package jobs
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestManager returns a manager on dir and a channel that receives every
+// job it finishes.
+func newTestManager(t *testing.T, dir string, workers int) (*Manager, chan Job) {
+	t.Helper()
+	finished := make(chan Job, 16)
+	m, err := NewManager(dir, workers, func(job Job) { finished <- job })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, finished
+}
+
+func waitFinished(t *testing.T, finished chan Job) Job {
+	t.Helper()
+	select {
+	case job := <-finished:
+		return job
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not finish")
+		return Job{}
+	}
+}
+
+func TestFinishedJobSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	m, finished := newTestManager(t, dir, 1)
+	job, err := m.Submit("create", "data", "ci", "aaaa", func(progress func(string)) (interface{}, error) {
+		progress("allocating")
+		return map[string]string{"status": "success"}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFinished(t, finished)
+
+	restarted, _ := newTestManager(t, dir, 1)
+	got, ok := restarted.Get(job.ID)
+	if !ok {
+		t.Fatalf("job %s not loaded after restart", job.ID)
+	}
+	var result map[string]string
+	if err := json.Unmarshal(got.Result, &result); err != nil {
+		t.Fatalf("decoding result %s: %v", got.Result, err)
+	}
+	if got.State != StateSucceeded || got.TokenID != "aaaa" || len(got.Steps) != 1 || result["status"] != "success" {
+		t.Errorf("reloaded job = %+v", got)
+	}
+}
+
+func TestRestartMarksUnfinishedJobsFailed(t *testing.T) {
+	dir := t.TempDir()
+	m, _ := newTestManager(t, dir, 1)
+	for _, state := range []State{StateQueued, StateRunning} {
+		job := &Job{ID: string(state), Type: "resize", Volume: "data", State: state, Steps: []Step{}, CreatedAt: time.Now().UTC()}
+		if err := m.persistLocked(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restarted, finished := newTestManager(t, dir, 1)
+	for _, id := range []string{string(StateQueued), string(StateRunning)} {
+		job, ok := restarted.Get(id)
+		if !ok || job.State != StateFailed || job.Error != "interrupted by service restart" || job.FinishedAt == nil {
+			t.Errorf("%s job after restart = %+v", id, job)
+		}
+		waitFinished(t, finished)
+	}
+}
+
+func TestSubmitFailsWhenQueueIsFull(t *testing.T) {
+	m, finished := newTestManager(t, t.TempDir(), 1)
+	release := make(chan struct{})
+	block := func(func(string)) (interface{}, error) {
+		<-release
+		return nil, nil
+	}
+
+	// One job occupies the worker and the rest fill the queue.
+	submitted := 0
+	for ; submitted <= queueSize; submitted++ {
+		if _, err := m.Submit("create", "data", "", "", block); err != nil {
+			t.Fatalf("job %d: %v", submitted, err)
+		}
+		if submitted == 0 {
+			for {
+				if job, _ := m.Get(firstJobID(m)); job.State == StateRunning {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+	if _, err := m.Submit("create", "data", "", "", block); err != ErrQueueFull {
+		t.Errorf("Submit on a full queue = %v, want ErrQueueFull", err)
+	}
+	if n := countFiles(t, m.dir); n != submitted {
+		t.Errorf("%d job files, want %d; the rejected job must not be kept", n, submitted)
+	}
+
+	close(release)
+	for i := 0; i < submitted; i++ {
+		waitFinished(t, finished)
+	}
+}
+
+func TestPanickingJobIsMarkedFailed(t *testing.T) {
+	m, finished := newTestManager(t, t.TempDir(), 1)
+	if _, err := m.Submit("resize", "data", "", "", func(func(string)) (interface{}, error) {
+		panic("boom")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if job := waitFinished(t, finished); job.State != StateFailed || job.Error != "job panicked: boom" {
+		t.Errorf("panicking job = %+v", job)
+	}
+
+	// The worker survived and still runs jobs.
+	if _, err := m.Submit("resize", "data", "", "", func(func(string)) (interface{}, error) { return nil, nil }); err != nil {
+		t.Fatal(err)
+	}
+	if job := waitFinished(t, finished); job.State != StateSucceeded {
+		t.Errorf("job after a panic = %+v", job)
+	}
+}
+
+func TestFinishingJobPrunesExpiredJobs(t *testing.T) {
+	m, finished := newTestManager(t, t.TempDir(), 1)
+	expired := time.Now().UTC().Add(-retention - time.Hour)
+	m.mu.Lock()
+	m.jobs["old"] = &Job{ID: "old", Type: "create", Volume: "data", State: StateSucceeded, Steps: []Step{}, CreatedAt: expired, FinishedAt: &expired}
+	err := m.persistLocked(m.jobs["old"])
+	m.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Submit("create", "data", "", "", func(func(string)) (interface{}, error) { return nil, nil }); err != nil {
+		t.Fatal(err)
+	}
+	waitFinished(t, finished)
+
+	// onFinish runs before pruning; wait for the file to go.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(m.jobPath("old")); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired job file was not removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := m.Get("old"); ok {
+		t.Errorf("expired job is still served")
+	}
+}
+
+func firstJobID(m *Manager) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id := range m.jobs {
+		return id
+	}
+	return ""
+}
+
+func countFiles(t *testing.T, dir string) int {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(entries)
+}
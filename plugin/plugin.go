@@ -0,0 +1,416 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"hubfly-storage/audit"
+	"hubfly-storage/volume"
+)
+
+// DefaultSocketPath is where Docker discovers the "hubfly" volume driver.
+const DefaultSocketPath = "/run/docker/plugins/hubfly.sock"
+
+// DriverName is recorded as the owning driver of volumes created through the
+// plugin. The plugin only lists and manages those volumes; volumes created
+// through the HTTP API belong to Docker's local driver.
+const DriverName = "hubfly"
+
+// bootIDPath changes on every boot, which tells persisted mounts of a
+// previous boot apart from those still active.
+const bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+const contentType = "application/vnd.docker.plugins.v1.2+json"
+
+type request struct {
+	Name string            `json:"Name"`
+	Opts map[string]string `json:"Opts,omitempty"`
+	ID   string            `json:"ID,omitempty"`
+}
+
+type volumeInfo struct {
+	Name       string                 `json:"Name"`
+	Mountpoint string                 `json:"Mountpoint,omitempty"`
+	CreatedAt  string                 `json:"CreatedAt,omitempty"`
+	Status     map[string]interface{} `json:"Status,omitempty"`
+}
+
+type response struct {
+	Err          string        `json:"Err"`
+	Mountpoint   string        `json:"Mountpoint,omitempty"`
+	Volume       *volumeInfo   `json:"Volume,omitempty"`
+	Volumes      []*volumeInfo `json:"Volumes,omitempty"`
+	Capabilities *capabilities `json:"Capabilities,omitempty"`
+}
+
+type capabilities struct {
+	Scope string `json:"Scope"`
+}
+
+// Driver implements the Docker Volume Plugin protocol on top of the volume
+// package. Docker owns the volume registration, so volumes created here are
+// never registered through the docker CLI.
+//
+// The mount references Docker holds are persisted to <baseDir>/.plugin so
+// that Remove still refuses a volume in use after hubfly-storage restarts.
+type Driver struct {
+	baseDir    string
+	mountsPath string
+	bootID     string
+	auditLog   *audit.Log
+
+	mu     sync.Mutex
+	mounts map[string]map[string]struct{}
+}
+
+// mountState is the persisted form of Driver.mounts, keyed by volume name.
+type mountState struct {
+	BootID string              `json:"boot_id"`
+	Mounts map[string][]string `json:"mounts"`
+}
+
+// NewDriver loads the mounts recorded by a previous run. Mounts recorded
+// during an earlier boot are dropped, since the reboot released them.
+func NewDriver(baseDir string, auditLog *audit.Log) (*Driver, error) {
+	d := &Driver{
+		baseDir:    baseDir,
+		mountsPath: filepath.Join(baseDir, ".plugin", "mounts.json"),
+		auditLog:   auditLog,
+		mounts:     make(map[string]map[string]struct{}),
+	}
+	if content, err := ioutil.ReadFile(bootIDPath); err == nil {
+		d.bootID = strings.TrimSpace(string(content))
+	}
+
+	content, err := ioutil.ReadFile(d.mountsPath)
+	if os.IsNotExist(err) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin mounts: %v", err)
+	}
+	var state mountState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("invalid plugin mounts file %s: %v", d.mountsPath, err)
+	}
+	if state.BootID != d.bootID {
+		log.Printf("Dropping plugin mounts recorded before the last reboot")
+		return d, nil
+	}
+	for name, ids := range state.Mounts {
+		d.mounts[name] = make(map[string]struct{})
+		for _, id := range ids {
+			d.mounts[name][id] = struct{}{}
+		}
+		log.Printf("Plugin volume %s has %d active mounts from a previous run", name, len(ids))
+	}
+	return d, nil
+}
+
+func (d *Driver) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string][]string{"Implements": {"VolumeDriver"}})
+	})
+	mux.HandleFunc("/VolumeDriver.Create", d.handle(d.audited("create", d.create)))
+	mux.HandleFunc("/VolumeDriver.Remove", d.handle(d.audited("delete", d.remove)))
+	mux.HandleFunc("/VolumeDriver.Mount", d.handle(d.mount))
+	mux.HandleFunc("/VolumeDriver.Unmount", d.handle(d.unmount))
+	mux.HandleFunc("/VolumeDriver.Path", d.handle(d.path))
+	mux.HandleFunc("/VolumeDriver.Get", d.handle(d.get))
+	mux.HandleFunc("/VolumeDriver.List", d.handle(d.list))
+	mux.HandleFunc("/VolumeDriver.Capabilities", d.handle(func(request) (*response, error) {
+		return &response{Capabilities: &capabilities{Scope: "local"}}, nil
+	}))
+	return mux
+}
+
+// Serve listens on socketPath and serves the plugin API until the listener
+// fails. A stale socket left behind by a previous run is removed first.
+func Serve(socketPath string, handler http.Handler) error {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0755); err != nil {
+		return fmt.Errorf("failed to create plugin socket directory: %v", err)
+	}
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale plugin socket: %v", err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on plugin socket: %v", err)
+	}
+
+	return http.Serve(listener, handler)
+}
+
+// audited records each call of fn in the audit log, if there is one.
+func (d *Driver) audited(operation string, fn func(request) (*response, error)) func(request) (*response, error) {
+	return func(req request) (*response, error) {
+		started := time.Now()
+		resp, err := fn(req)
+		if d.auditLog != nil {
+			audit.RecordPlugin(d.auditLog, operation, req.Name, req.Opts, started, err)
+		}
+		return resp, err
+	}
+}
+
+func (d *Driver) handle(fn func(request) (*response, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req request
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeJSON(w, http.StatusBadRequest, response{Err: fmt.Sprintf("invalid JSON payload: %v", err)})
+				return
+			}
+		}
+		defer r.Body.Close()
+
+		if req.Name != "" {
+			if _, err := volume.ParseVolumeName(req.Name); err != nil {
+				writeJSON(w, http.StatusBadRequest, response{Err: err.Error()})
+				return
+			}
+		}
+
+		resp, err := fn(req)
+		if err != nil {
+			log.Printf("❌ plugin %s %s: %v", r.URL.Path, req.Name, err)
+			writeJSON(w, http.StatusInternalServerError, response{Err: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+func (d *Driver) create(req request) (*response, error) {
+	log.Printf("Plugin request to create volume: %s", req.Name)
+
+	name, err := volume.ParseVolumeName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	config, err := volume.ConfigFromDriverOpts(req.Opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	config.Driver = DriverName
+
+	if _, err := volume.CreateVolume(name, d.baseDir, config); err != nil {
+		return nil, err
+	}
+	return &response{}, nil
+}
+
+func (d *Driver) remove(req request) (*response, error) {
+	log.Printf("Plugin request to remove volume: %s", req.Name)
+
+	name, err := volume.ParseVolumeName(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	// Docker retries Remove for volumes it still lists, so a volume whose
+	// directory is already gone counts as removed.
+	if _, err := d.lookup(req.Name); err != nil {
+		if volume.IsNotFoundError(err) {
+			log.Printf("Volume %s is already gone; nothing to remove", req.Name)
+			return &response{}, nil
+		}
+		return nil, err
+	}
+
+	d.mu.Lock()
+	inUse := len(d.mounts[req.Name])
+	d.mu.Unlock()
+	if inUse > 0 {
+		return nil, fmt.Errorf("volume '%s' is still mounted by %d containers", req.Name, inUse)
+	}
+
+	if err := volume.DeleteVolumeData(name, d.baseDir); err != nil && !volume.IsNotFoundError(err) {
+		return nil, err
+	}
+	return &response{}, nil
+}
+
+func (d *Driver) mount(req request) (*response, error) {
+	mountpoint, err := d.mountpoint(req.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := volume.ReattachVolume(volume.VolumeName(req.Name), d.baseDir); err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.mounts[req.Name] == nil {
+		d.mounts[req.Name] = make(map[string]struct{})
+	}
+	d.mounts[req.Name][req.ID] = struct{}{}
+	if err := d.saveMountsLocked(); err != nil {
+		// Docker sees the mount fail, so it must not be counted either.
+		delete(d.mounts[req.Name], req.ID)
+		if len(d.mounts[req.Name]) == 0 {
+			delete(d.mounts, req.Name)
+		}
+		return nil, err
+	}
+	log.Printf("Plugin mounted volume %s for %s (%d active)", req.Name, req.ID, len(d.mounts[req.Name]))
+
+	return &response{Mountpoint: mountpoint}, nil
+}
+
+func (d *Driver) unmount(req request) (*response, error) {
+	if _, err := d.mountpoint(req.Name); err != nil {
+		return nil, err
+	}
+
+	// The image stays mounted at _data; only Docker's reference is dropped.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.mounts[req.Name], req.ID)
+	log.Printf("Plugin unmounted volume %s for %s (%d active)", req.Name, req.ID, len(d.mounts[req.Name]))
+	if len(d.mounts[req.Name]) == 0 {
+		delete(d.mounts, req.Name)
+	}
+	if err := d.saveMountsLocked(); err != nil {
+		// The stale reference only keeps Remove refusing the volume.
+		log.Printf("warning: %v", err)
+	}
+
+	return &response{}, nil
+}
+
+func (d *Driver) path(req request) (*response, error) {
+	mountpoint, err := d.mountpoint(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &response{Mountpoint: mountpoint}, nil
+}
+
+func (d *Driver) get(req request) (*response, error) {
+	info, err := d.volumeInfo(req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &response{Volume: info}, nil
+}
+
+func (d *Driver) list(request) (*response, error) {
+	names, err := volume.ListVolumeNames(d.baseDir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	volumes := make([]*volumeInfo, 0, len(names))
+	for _, name := range names {
+		if meta, err := volume.GetVolumeMetadata(volume.VolumeName(name), d.baseDir); err == nil && !meta.OwnedBy(DriverName) {
+			continue
+		}
+		info, err := d.volumeInfo(name)
+		if err != nil {
+			log.Printf("failed to describe volume %s: %v", name, err)
+			continue
+		}
+		volumes = append(volumes, info)
+	}
+	return &response{Volumes: volumes}, nil
+}
+
+func (d *Driver) volumeInfo(name string) (*volumeInfo, error) {
+	meta, err := d.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	mountpoint, err := d.mountpoint(name)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	activeMounts := len(d.mounts[name])
+	d.mu.Unlock()
+
+	return &volumeInfo{
+		Name:       name,
+		Mountpoint: mountpoint,
+		CreatedAt:  meta.CreatedAt.Format(time.RFC3339),
+		Status: map[string]interface{}{
+			"size_bytes":    meta.SizeBytes,
+			"optimization":  meta.Optimization,
+			"encrypted":     meta.Encrypted,
+			"active_mounts": activeMounts,
+		},
+	}, nil
+}
+
+// lookup returns the metadata of a volume the plugin owns. Other volumes
+// are reported as not found, so Docker cannot mount or remove a volume of
+// the local driver through this plugin.
+func (d *Driver) lookup(name string) (*volume.Metadata, error) {
+	if name == "" {
+		return nil, fmt.Errorf("volume name is required")
+	}
+	meta, err := volume.GetVolumeMetadata(volume.VolumeName(name), d.baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if !meta.OwnedBy(DriverName) {
+		return nil, fmt.Errorf("volume '%s' not found; it belongs to the %s driver", name, meta.DockerDriver())
+	}
+	return meta, nil
+}
+
+func (d *Driver) mountpoint(name string) (string, error) {
+	if _, err := d.lookup(name); err != nil {
+		return "", err
+	}
+	return filepath.Abs(filepath.Join(d.baseDir, name, "_data"))
+}
+
+// saveMountsLocked writes the mounts through a temp file and a rename.
+func (d *Driver) saveMountsLocked() error {
+	state := mountState{BootID: d.bootID, Mounts: make(map[string][]string, len(d.mounts))}
+	for name, ids := range d.mounts {
+		for id := range ids {
+			state.Mounts[name] = append(state.Mounts[name], id)
+		}
+		sort.Strings(state.Mounts[name])
+	}
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(d.mountsPath), 0700); err != nil {
+		return fmt.Errorf("failed to record plugin mounts: %v", err)
+	}
+	tmpPath := d.mountsPath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0600); err != nil {
+		return fmt.Errorf("failed to record plugin mounts: %v", err)
+	}
+	if err := os.Rename(tmpPath, d.mountsPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to record plugin mounts: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
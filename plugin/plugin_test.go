@@ -0,0 +1,205 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"hubfly-storage/audit"
+	"hubfly-storage/volume"
+)
+
+func writeVolume(t *testing.T, baseDir, name, driver string) {
+	t.Helper()
+	volumePath := filepath.Join(baseDir, name)
+	if err := os.MkdirAll(filepath.Join(volumePath, "_data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(volumePath, "volume.img"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := json.Marshal(volume.Metadata{
+		Name:         name,
+		Optimization: volume.OptimizationStandard,
+		Driver:       driver,
+		CreatedAt:    time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(volumePath, "volume.json"), meta, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func call(t *testing.T, handler http.Handler, endpoint string, req request) response {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/VolumeDriver."+endpoint, bytes.NewReader(body)))
+	var resp response
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%s: %v: %s", endpoint, err, rec.Body.String())
+	}
+	return resp
+}
+
+func TestDriverOnlyManagesOwnVolumes(t *testing.T) {
+	baseDir := t.TempDir()
+	writeVolume(t, baseDir, "api-volume", "")
+	writeVolume(t, baseDir, "local-volume", volume.DriverLocal)
+	writeVolume(t, baseDir, "plugin-volume", DriverName)
+
+	driver, err := NewDriver(baseDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := driver.Handler()
+
+	resp := call(t, handler, "List", request{})
+	if len(resp.Volumes) != 1 || resp.Volumes[0].Name != "plugin-volume" {
+		t.Errorf("List = %+v, want only plugin-volume", resp.Volumes)
+	}
+
+	for _, name := range []string{"api-volume", "local-volume"} {
+		for _, endpoint := range []string{"Get", "Path", "Mount", "Unmount", "Remove"} {
+			if resp := call(t, handler, endpoint, request{Name: name, ID: "c1"}); !strings.Contains(resp.Err, "not found") {
+				t.Errorf("%s %s: Err = %q, want not found", endpoint, name, resp.Err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(baseDir, name, "volume.img")); err != nil {
+			t.Errorf("%s was touched by the plugin: %v", name, err)
+		}
+	}
+
+	if resp := call(t, handler, "Get", request{Name: "plugin-volume"}); resp.Err != "" || resp.Volume == nil {
+		t.Errorf("Get plugin-volume = %+v", resp)
+	}
+}
+
+// Removing a volume whose directory was already deleted succeeds, so Docker
+// can drop its own record of it.
+func TestRemoveOfDeletedVolumeSucceeds(t *testing.T) {
+	baseDir := t.TempDir()
+	writeVolume(t, baseDir, "plugin-volume", DriverName)
+	driver, err := NewDriver(baseDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := driver.Handler()
+	if resp := call(t, handler, "Get", request{Name: "plugin-volume"}); resp.Err != "" {
+		t.Fatalf("Get: %s", resp.Err)
+	}
+
+	if err := os.RemoveAll(filepath.Join(baseDir, "plugin-volume")); err != nil {
+		t.Fatal(err)
+	}
+	if resp := call(t, handler, "Remove", request{Name: "plugin-volume"}); resp.Err != "" {
+		t.Errorf("Remove of a deleted volume: Err = %q, want success", resp.Err)
+	}
+}
+
+func TestMountsSurviveRestart(t *testing.T) {
+	baseDir := t.TempDir()
+	writeVolume(t, baseDir, "plugin-volume", DriverName)
+
+	driver, err := NewDriver(baseDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Mount reattaches the image with sudo, so record the reference as
+	// Mount does.
+	driver.mu.Lock()
+	driver.mounts["plugin-volume"] = map[string]struct{}{"c1": {}}
+	if err := driver.saveMountsLocked(); err != nil {
+		t.Fatal(err)
+	}
+	driver.mu.Unlock()
+
+	restarted, err := NewDriver(baseDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := restarted.Handler()
+	if resp := call(t, handler, "Remove", request{Name: "plugin-volume"}); !strings.Contains(resp.Err, "still mounted") {
+		t.Errorf("Remove after restart: Err = %q, want still mounted", resp.Err)
+	}
+	if resp := call(t, handler, "Unmount", request{Name: "plugin-volume", ID: "c1"}); resp.Err != "" {
+		t.Fatalf("Unmount: %s", resp.Err)
+	}
+
+	restarted, err = NewDriver(baseDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(restarted.mounts["plugin-volume"]); n != 0 {
+		t.Errorf("%d mounts after Unmount and restart, want 0", n)
+	}
+}
+
+func TestMountsFromAnotherBootAreDropped(t *testing.T) {
+	baseDir := t.TempDir()
+	driver, err := NewDriver(baseDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver.bootID = "previous-boot"
+	driver.mounts["plugin-volume"] = map[string]struct{}{"c1": {}}
+	if err := driver.saveMountsLocked(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := NewDriver(baseDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(restarted.mounts["plugin-volume"]); n != 0 {
+		t.Errorf("%d mounts from another boot kept, want 0", n)
+	}
+}
+
+func TestCreateAndRemoveAreAudited(t *testing.T) {
+	baseDir := t.TempDir()
+	auditLog, err := audit.Open(filepath.Join(baseDir, ".audit", "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver, err := NewDriver(baseDir, auditLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := driver.Handler()
+
+	// Neither call reaches sudo: the size is invalid and the volume does
+	// not exist, which Remove treats as already removed.
+	call(t, handler, "Create", request{Name: "plugin-volume", Opts: map[string]string{"size": "lots", "encryption_key": "hunter2"}})
+	call(t, handler, "Remove", request{Name: "plugin-volume"})
+	call(t, handler, "List", request{})
+
+	entries, err := auditLog.Query(audit.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d audit entries, want create and delete: %+v", len(entries), entries)
+	}
+	for i, want := range []struct{ operation, outcome string }{
+		{"create", audit.OutcomeFailure},
+		{"delete", audit.OutcomeSuccess},
+	} {
+		entry := entries[i]
+		if entry.Operation != want.operation || entry.Volume != "plugin-volume" || entry.Principal != audit.PrincipalDocker || entry.Outcome != want.outcome {
+			t.Errorf("entry %d = %+v", i, entry)
+		}
+	}
+	if key := entries[0].Params["opt.encryption_key"]; key == "hunter2" {
+		t.Errorf("encryption key recorded in the audit log")
+	}
+}
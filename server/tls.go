@@ -0,0 +1,160 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// reloadCheckInterval bounds how often certificate files are stat'ed during
+// handshakes.
+const reloadCheckInterval = 5 * time.Second
+
+// TLSFiles are the paths of the server certificate, its key and an optional
+// CA bundle used to verify client certificates.
+type TLSFiles struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+func (f TLSFiles) Enabled() bool {
+	return f.CertFile != "" || f.KeyFile != "" || f.ClientCAFile != ""
+}
+
+func (f TLSFiles) Validate() error {
+	if !f.Enabled() {
+		return nil
+	}
+	if f.CertFile == "" || f.KeyFile == "" {
+		return errors.New("TLS requires both a certificate and a key file")
+	}
+	return nil
+}
+
+// CertReloader serves the certificate, key and client CA pool from disk and
+// picks up replaced files on the next handshake, so certificates can be
+// rotated without restarting the service.
+type CertReloader struct {
+	files TLSFiles
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  [3]time.Time
+	lastCheck time.Time
+}
+
+// NewCertReloader loads the files once and fails if they are unusable.
+func NewCertReloader(files TLSFiles) (*CertReloader, error) {
+	if err := files.Validate(); err != nil {
+		return nil, err
+	}
+	r := &CertReloader{files: files}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload re-reads the files. On failure the previous certificate stays in use.
+func (r *CertReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloadLocked()
+}
+
+func (r *CertReloader) reloadLocked() error {
+	modTimes, err := r.statFiles()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.files.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.files.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA bundle: %v", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA bundle %s", r.files.ClientCAFile)
+		}
+	}
+
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	r.lastCheck = time.Now()
+	return nil
+}
+
+func (r *CertReloader) statFiles() ([3]time.Time, error) {
+	var modTimes [3]time.Time
+	for i, path := range []string{r.files.CertFile, r.files.KeyFile, r.files.ClientCAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, fmt.Errorf("failed to stat %s: %v", path, err)
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (r *CertReloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) >= reloadCheckInterval {
+		r.lastCheck = time.Now()
+		if modTimes, err := r.statFiles(); err != nil {
+			log.Printf("TLS reload warning: %v", err)
+		} else if modTimes != r.modTimes {
+			if err := r.reloadLocked(); err != nil {
+				log.Printf("TLS reload warning: keeping previous certificate: %v", err)
+			} else {
+				log.Printf("Reloaded TLS certificate from %s", r.files.CertFile)
+			}
+		}
+	}
+	return r.cert, r.clientCAs
+}
+
+// TLSConfig returns a server configuration that resolves the certificate and
+// client CA pool per handshake. Client certificates are required and
+// verified when a client CA bundle is configured.
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			return cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, clientCAs := r.current()
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if clientCAs != nil {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+				config.ClientCAs = clientCAs
+			}
+			return config, nil
+		},
+	}
+}
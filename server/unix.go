@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// UnixSocketOptions controls the ownership, permissions and callers of the
+// API unix socket.
+type UnixSocketOptions struct {
+	// UID and GID own the socket file; -1 leaves the process default.
+	UID  int
+	GID  int
+	Mode os.FileMode
+	// AllowedUIDs restricts connections to peers running as one of these
+	// UIDs, checked with SO_PEERCRED. An empty list allows every peer that
+	// can open the socket.
+	AllowedUIDs []uint32
+}
+
+// ListenUnix creates the socket at path, replacing a stale one, and applies
+// the ownership, mode and peer allow-list from opts. The socket is bound in
+// a private directory and only renamed into place once its mode and owner
+// are set, so no other process can connect while it is still open to all.
+func ListenUnix(path string, opts UnixSocketOptions) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %v", err)
+	}
+	privateDir, err := ioutil.TempDir(dir, ".socket-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %v", err)
+	}
+	defer os.RemoveAll(privateDir)
+
+	tmpPath := filepath.Join(privateDir, filepath.Base(path))
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on socket: %v", err)
+	}
+	// The listener would unlink tmpPath on Close; Close removes path instead.
+	listener.SetUnlinkOnClose(false)
+	fail := func(format string, err error) (net.Listener, error) {
+		listener.Close()
+		return nil, fmt.Errorf(format, err)
+	}
+
+	if err := os.Chmod(tmpPath, opts.Mode); err != nil {
+		return fail("failed to set socket mode: %v", err)
+	}
+	if opts.UID != -1 || opts.GID != -1 {
+		if err := os.Chown(tmpPath, opts.UID, opts.GID); err != nil {
+			return fail("failed to set socket owner: %v", err)
+		}
+	}
+	// Rename replaces a stale socket left by a previous run.
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fail("failed to move socket into place: %v", err)
+	}
+
+	return &peerCredListener{Listener: listener, path: path, allowed: opts.AllowedUIDs}, nil
+}
+
+type peerCredListener struct {
+	net.Listener
+	path    string
+	allowed []uint32
+}
+
+// Close stops listening and removes the socket file.
+func (l *peerCredListener) Close() error {
+	err := l.Listener.Close()
+	if removeErr := os.Remove(l.path); removeErr != nil && !os.IsNotExist(removeErr) && err == nil {
+		err = removeErr
+	}
+	return err
+}
+
+// Accept drops connections from peers outside the UID allow-list.
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		uid, err := peerUID(conn)
+		if err != nil && len(l.allowed) == 0 {
+			return conn, nil
+		}
+		if err != nil {
+			log.Printf("❌ Rejected unix socket connection: %v", err)
+			conn.Close()
+			continue
+		}
+		if !l.allows(uid) {
+			log.Printf("❌ Rejected unix socket connection from uid %d", uid)
+			conn.Close()
+			continue
+		}
+		return &peerConn{Conn: conn, uid: uid}, nil
+	}
+}
+
+func (l *peerCredListener) allows(uid uint32) bool {
+	if len(l.allowed) == 0 {
+		return true
+	}
+	for _, allowed := range l.allowed {
+		if uid == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+type peerConn struct {
+	net.Conn
+	uid uint32
+}
+
+type peerUIDKey struct{}
+
+// ConnContext records the peer UID of unix socket connections on the request
+// context. Use it as http.Server.ConnContext.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	if pc, ok := conn.(*peerConn); ok {
+		return context.WithValue(ctx, peerUIDKey{}, pc.uid)
+	}
+	return ctx
+}
+
+// PeerUID returns the UID of the process on the other end of a unix socket
+// request. The audit log records it for requests made over the socket.
+func PeerUID(ctx context.Context) (uint32, bool) {
+	uid, ok := ctx.Value(peerUIDKey{}).(uint32)
+	return uid, ok
+}
+
+// ParseOwner parses "user[:group]", where either part is a name or a numeric
+// ID. An empty spec leaves ownership unchanged.
+func ParseOwner(spec string) (int, int, error) {
+	uid, gid := -1, -1
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return uid, gid, nil
+	}
+
+	userPart, groupPart := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		userPart, groupPart = spec[:i], spec[i+1:]
+	}
+
+	if userPart != "" {
+		id, err := lookupID(userPart, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid socket owner '%s': %v", userPart, err)
+		}
+		uid = id
+	}
+	if groupPart != "" {
+		id, err := lookupID(groupPart, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid socket group '%s': %v", groupPart, err)
+		}
+		gid = id
+	}
+	return uid, gid, nil
+}
+
+func lookupID(value string, lookup func(string) (string, error)) (int, error) {
+	if id, err := strconv.Atoi(value); err == nil {
+		return id, nil
+	}
+	raw, err := lookup(value)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(raw)
+}
+
+// ParseMode parses an octal file mode such as "0660".
+func ParseMode(raw string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimSpace(raw), 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid socket mode '%s': expected octal permissions such as 0660", raw)
+	}
+	return os.FileMode(mode), nil
+}
+
+// ParseUIDs parses a comma-separated list of numeric UIDs.
+func ParseUIDs(raw string) ([]uint32, error) {
+	var uids []uint32
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		uid, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid '%s'", part)
+		}
+		uids = append(uids, uint32(uid))
+	}
+	return uids, nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestListenUnixSetsModeBeforeExposingSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "api.sock")
+	// A stale socket from a previous run is replaced.
+	if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := ListenUnix(path, UnixSocketOptions{UID: -1, GID: -1, Mode: 0600})
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %v, want a socket with 0600", info.Mode())
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d entries next to the socket, want only the socket", len(entries))
+	}
+
+	peerUIDs := make(chan uint32, 1)
+	server := &http.Server{ConnContext: ConnContext, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, _ := PeerUID(r.Context())
+		peerUIDs <- uid
+	})}
+	go server.Serve(listener)
+
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := httpClient.Get("http://unix/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// Peer credentials are only read on Linux.
+	if uid := <-peerUIDs; runtime.GOOS == "linux" && uid != uint32(os.Getuid()) {
+		t.Errorf("PeerUID = %d, want %d", uid, os.Getuid())
+	}
+
+	server.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket left behind after Close: %v", err)
+	}
+}
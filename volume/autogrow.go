@@ -0,0 +1,249 @@
+package volume
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxAutogrowEvents = 100
+
+// AutogrowPolicy grows a volume by Step whenever its filesystem usage
+// reaches ThresholdPercent, never beyond MaxBytes. Step is either a size
+// such as "5G" or a percentage of the current size such as "25%".
+type AutogrowPolicy struct {
+	ThresholdPercent float64 `json:"threshold_percent"`
+	Step             string  `json:"step"`
+	MaxSize          string  `json:"max_size"`
+	MaxBytes         int64   `json:"max_bytes"`
+}
+
+type AutogrowEvent struct {
+	Volume            string    `json:"volume"`
+	UsagePercent      float64   `json:"usage_percent"`
+	ThresholdPercent  float64   `json:"threshold_percent"`
+	PreviousSizeBytes int64     `json:"previous_size_bytes"`
+	NewSizeBytes      int64     `json:"new_size_bytes"`
+	Time              time.Time `json:"time"`
+	// Error is set when the resize failed; NewSizeBytes is then the size
+	// that was attempted.
+	Error     string `json:"error,omitempty"`
+	ErrorCode string `json:"error_code,omitempty"`
+}
+
+// ParseAutogrowPolicy validates the driver option values for an autogrow
+// policy. All three values are required, and the threshold is a percentage
+// from 1 to 99.
+func ParseAutogrowPolicy(threshold, step, maxSize string) (*AutogrowPolicy, error) {
+	threshold = strings.TrimSuffix(strings.TrimSpace(threshold), "%")
+	step = strings.TrimPrefix(strings.TrimSpace(step), "+")
+	maxSize = strings.TrimSpace(maxSize)
+	if threshold == "" || step == "" || maxSize == "" {
+		return nil, validationErrorf("autogrow requires autogrow_threshold, autogrow_step and autogrow_max")
+	}
+
+	thresholdPercent, err := strconv.ParseFloat(threshold, 64)
+	if err != nil || thresholdPercent < 1 || thresholdPercent > 99 {
+		return nil, validationErrorf("autogrow_threshold must be a percentage from 1 to 99")
+	}
+
+	if _, err := resolveRequestedSize("+"+step, 1); err != nil {
+		return nil, validationErrorf("invalid autogrow_step: %v", err)
+	}
+
+	maxBytes, err := parseSizeToBytes(maxSize)
+	if err != nil {
+		return nil, validationErrorf("invalid autogrow_max: %v", err)
+	}
+
+	return &AutogrowPolicy{
+		ThresholdPercent: thresholdPercent,
+		Step:             step,
+		MaxSize:          maxSize,
+		MaxBytes:         maxBytes,
+	}, nil
+}
+
+// checkSize rejects a policy whose maximum is below the volume's current
+// size, which could never grow it. A nil policy always passes.
+func (p *AutogrowPolicy) checkSize(sizeBytes int64) error {
+	if p != nil && p.MaxBytes < sizeBytes {
+		return validationErrorf("autogrow_max of %d bytes is smaller than the current size of %d bytes", p.MaxBytes, sizeBytes)
+	}
+	return nil
+}
+
+// SetAutogrowPolicy stores policy in the volume's metadata. A nil policy
+// turns autogrow off.
+func SetAutogrowPolicy(name VolumeName, baseDir string, policy *AutogrowPolicy) (*Metadata, error) {
+	unlock, err := acquireVolumeLock(baseDir, name, "update autogrow policy of")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	meta, err := loadMetadata(name, baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := policy.checkSize(meta.SizeBytes); err != nil {
+		return nil, err
+	}
+	meta.Autogrow = policy
+	if err := writeMetadata(filepath.Join(baseDir, name.String()), meta); err != nil {
+		return nil, fmt.Errorf("failed to write volume metadata: %v", err)
+	}
+	return meta, nil
+}
+
+// AutogrowWatcher periodically checks every volume with an autogrow policy
+// and grows the ones whose usage crossed their threshold. onGrow is called
+// for every resize attempt, including failed ones.
+type AutogrowWatcher struct {
+	baseDir  string
+	interval time.Duration
+	onGrow   func(AutogrowEvent)
+
+	mu     sync.Mutex
+	events []AutogrowEvent
+}
+
+func NewAutogrowWatcher(baseDir string, interval time.Duration, onGrow func(AutogrowEvent)) *AutogrowWatcher {
+	return &AutogrowWatcher{
+		baseDir:  baseDir,
+		interval: interval,
+		onGrow:   onGrow,
+	}
+}
+
+// Run checks all volumes every interval until ctx is cancelled.
+func (w *AutogrowWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		w.CheckOnce()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *AutogrowWatcher) CheckOnce() {
+	names, err := ListVolumeNames(w.baseDir)
+	if err != nil {
+		log.Printf("autogrow: %v", err)
+		return
+	}
+
+	for _, name := range names {
+		event, err := w.check(name)
+		if err != nil {
+			log.Printf("autogrow: failed to check %s: %v", name, err)
+		}
+		if event == nil {
+			continue
+		}
+
+		w.mu.Lock()
+		w.events = append(w.events, *event)
+		if len(w.events) > maxAutogrowEvents {
+			w.events = w.events[len(w.events)-maxAutogrowEvents:]
+		}
+		w.mu.Unlock()
+
+		if w.onGrow != nil {
+			w.onGrow(*event)
+		}
+	}
+}
+
+// RecentEvents returns the most recent growth events, oldest first.
+func (w *AutogrowWatcher) RecentEvents() []AutogrowEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	events := make([]AutogrowEvent, len(w.events))
+	copy(events, w.events)
+	return events
+}
+
+func (w *AutogrowWatcher) check(name string) (*AutogrowEvent, error) {
+	meta, err := loadMetadata(VolumeName(name), w.baseDir)
+	if err != nil {
+		return nil, err
+	}
+	policy := meta.Autogrow
+	if policy == nil {
+		return nil, nil
+	}
+
+	dataPath := filepath.Join(w.baseDir, name, "_data")
+	if !isMountPoint(dataPath) {
+		return nil, nil
+	}
+
+	fsBytes, usedBytes, err := mountedUsage(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	if fsBytes <= 0 {
+		return nil, nil
+	}
+
+	usagePercent := float64(usedBytes) * 100 / float64(fsBytes)
+	if usagePercent < policy.ThresholdPercent {
+		return nil, nil
+	}
+
+	targetBytes, err := resolveRequestedSize("+"+policy.Step, meta.SizeBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid autogrow step: %v", err)
+	}
+	if targetBytes > policy.MaxBytes {
+		targetBytes = policy.MaxBytes
+	}
+	if limits := CurrentLimits(); limits.MaxSizeBytes > 0 && targetBytes > limits.MaxSizeBytes {
+		targetBytes = limits.MaxSizeBytes
+	}
+	if targetBytes <= meta.SizeBytes {
+		log.Printf("autogrow: %s is at %.1f%% but already at its maximum size of %s", name, usagePercent, policy.MaxSize)
+		return nil, nil
+	}
+
+	log.Printf("autogrow: %s is at %.1f%% (threshold %.1f%%); growing to %d bytes", name, usagePercent, policy.ThresholdPercent, targetBytes)
+	previousBytes, newBytes, err := ResizeVolume(VolumeName(name), w.baseDir, strconv.FormatInt(targetBytes, 10))
+	if err != nil {
+		if IsConflictError(err) {
+			log.Printf("autogrow: %s is busy; retrying on the next check: %v", name, err)
+			return nil, nil
+		}
+		return &AutogrowEvent{
+			Volume:            name,
+			UsagePercent:      usagePercent,
+			ThresholdPercent:  policy.ThresholdPercent,
+			PreviousSizeBytes: meta.SizeBytes,
+			NewSizeBytes:      targetBytes,
+			Time:              time.Now().UTC(),
+			Error:             err.Error(),
+			ErrorCode:         ErrorCode(err),
+		}, err
+	}
+
+	return &AutogrowEvent{
+		Volume:            name,
+		UsagePercent:      usagePercent,
+		ThresholdPercent:  policy.ThresholdPercent,
+		PreviousSizeBytes: previousBytes,
+		NewSizeBytes:      newBytes,
+		Time:              time.Now().UTC(),
+	}, nil
+}
@@ -0,0 +1,177 @@
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// Error codes are stable identifiers for failures, reported to API callers
+// alongside the human-readable message.
+const (
+	CodeInvalidRequest        = "invalid_request"
+	CodeVolumeNotFound        = "volume_not_found"
+	CodeVolumeExists          = "volume_exists"
+	CodeSnapshotNotFound      = "snapshot_not_found"
+	CodeSnapshotExists        = "snapshot_exists"
+	CodeInsufficientHostSpace = "insufficient_host_space"
+	CodeEncryptionKeyMissing  = "encryption_key_missing"
+	CodeOperationInProgress   = "operation_in_progress"
+	CodeVolumeHasSnapshots    = "volume_has_snapshots"
+	CodeInternal              = "internal_error"
+)
+
+// ErrorCode returns the code of the first typed error in err's chain, or
+// CodeInternal.
+func ErrorCode(err error) string {
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+	return CodeInternal
+}
+
+// NotFoundError is returned when a volume, or a snapshot of it, does not
+// exist.
+type NotFoundError struct {
+	Volume   string
+	Snapshot string
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Snapshot != "" {
+		return fmt.Sprintf("snapshot '%s' not found for volume '%s'", e.Snapshot, e.Volume)
+	}
+	return fmt.Sprintf("volume '%s' not found", e.Volume)
+}
+
+func (e *NotFoundError) ErrorCode() string {
+	if e.Snapshot != "" {
+		return CodeSnapshotNotFound
+	}
+	return CodeVolumeNotFound
+}
+
+func IsNotFoundError(err error) bool {
+	var notFoundErr *NotFoundError
+	return errors.As(err, &notFoundErr)
+}
+
+// ExistsError is returned when creating a volume or snapshot whose name is
+// already taken.
+type ExistsError struct {
+	Volume   string
+	Snapshot string
+}
+
+func (e *ExistsError) Error() string {
+	if e.Snapshot != "" {
+		return fmt.Sprintf("snapshot '%s' already exists for volume '%s'", e.Snapshot, e.Volume)
+	}
+	return fmt.Sprintf("volume '%s' already exists", e.Volume)
+}
+
+func (e *ExistsError) ErrorCode() string {
+	if e.Snapshot != "" {
+		return CodeSnapshotExists
+	}
+	return CodeVolumeExists
+}
+
+func IsExistsError(err error) bool {
+	var existsErr *ExistsError
+	return errors.As(err, &existsErr)
+}
+
+// HasSnapshotsError is returned when deleting a volume that still has
+// snapshots without asking for them to be deleted too.
+type HasSnapshotsError struct {
+	Volume    string
+	Snapshots int
+}
+
+func (e *HasSnapshotsError) Error() string {
+	return fmt.Sprintf("volume '%s' has %d snapshot(s) that would be deleted with it; delete them first or ask for them to be deleted too", e.Volume, e.Snapshots)
+}
+
+func (e *HasSnapshotsError) ErrorCode() string {
+	return CodeVolumeHasSnapshots
+}
+
+// InsufficientSpaceError is returned when the host filesystem holding the
+// base directory cannot fit an allocation.
+type InsufficientSpaceError struct {
+	Path           string
+	RequiredBytes  int64
+	AvailableBytes int64
+	Err            error
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("not enough space on the host filesystem for %s: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("not enough space on the host filesystem for %s: %d bytes required, %d bytes available", e.Path, e.RequiredBytes, e.AvailableBytes)
+}
+
+func (e *InsufficientSpaceError) ErrorCode() string {
+	return CodeInsufficientHostSpace
+}
+
+func (e *InsufficientSpaceError) Unwrap() error {
+	return e.Err
+}
+
+func IsInsufficientSpaceError(err error) bool {
+	var spaceErr *InsufficientSpaceError
+	return errors.As(err, &spaceErr)
+}
+
+// EncryptionKeyError is returned when an encrypted volume has to be
+// formatted or opened and no key is available.
+type EncryptionKeyError struct {
+	Volume string
+}
+
+func (e *EncryptionKeyError) Error() string {
+	if e.Volume != "" {
+		return fmt.Sprintf("volume '%s' is encrypted but no key was provided; set DriverOpts.encryption_key or VOLUME_ENCRYPTION_KEY", e.Volume)
+	}
+	return "encryption requested but no key provided; set DriverOpts.encryption_key or VOLUME_ENCRYPTION_KEY"
+}
+
+func (e *EncryptionKeyError) ErrorCode() string {
+	return CodeEncryptionKeyMissing
+}
+
+func (e *ValidationError) ErrorCode() string {
+	return CodeInvalidRequest
+}
+
+func (e *ConflictError) ErrorCode() string {
+	return CodeOperationInProgress
+}
+
+// ensureHostSpace fails with an InsufficientSpaceError when the filesystem
+// holding path has fewer than required bytes available.
+func ensureHostSpace(path string, required int64) error {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return fmt.Errorf("failed to inspect free space on %s: %v", path, err)
+	}
+	available := int64(stat.Bavail) * int64(stat.Bsize)
+	if available < required {
+		return &InsufficientSpaceError{Path: path, RequiredBytes: required, AvailableBytes: available}
+	}
+	return nil
+}
+
+// asHostSpaceError turns a failed allocation or copy that ran out of space
+// into an InsufficientSpaceError and leaves other errors untouched.
+func asHostSpaceError(err error, path string) error {
+	if err != nil && strings.Contains(err.Error(), "No space left on device") {
+		return &InsufficientSpaceError{Path: path, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package volume
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// Limits are the service-wide defaults applied to new volumes and the cap on
+// their size. They are read on every create and resize, so SetLimits takes
+// effect for the next request.
+type Limits struct {
+	DefaultSize         string
+	DefaultOptimization OptimizationMode
+	// MaxSize caps new and resized volumes; empty means no cap.
+	MaxSize      string
+	MaxSizeBytes int64
+}
+
+var currentLimits atomic.Value
+
+func init() {
+	currentLimits.Store(DefaultLimits())
+}
+
+func DefaultLimits() Limits {
+	return Limits{DefaultSize: "1G", DefaultOptimization: OptimizationStandard}
+}
+
+// ParseLimits validates configured limits. The default size must itself fit
+// under the cap.
+func ParseLimits(defaultSize, optimization, maxSize string) (Limits, error) {
+	limits := DefaultLimits()
+
+	if defaultSize = strings.TrimSpace(defaultSize); defaultSize != "" {
+		limits.DefaultSize = defaultSize
+	}
+	defaultBytes, err := parseSizeToBytes(limits.DefaultSize)
+	if err != nil {
+		return Limits{}, validationErrorf("invalid default size '%s': %v", limits.DefaultSize, err)
+	}
+
+	if limits.DefaultOptimization, err = normalizeOptimization(optimization); err != nil {
+		return Limits{}, validationErrorf("invalid default optimization: %v", err)
+	}
+
+	if maxSize = strings.TrimSpace(maxSize); maxSize != "" {
+		maxBytes, err := parseSizeToBytes(maxSize)
+		if err != nil {
+			return Limits{}, validationErrorf("invalid max size '%s': %v", maxSize, err)
+		}
+		if defaultBytes > maxBytes {
+			return Limits{}, validationErrorf("default size %s exceeds the max size %s", limits.DefaultSize, maxSize)
+		}
+		limits.MaxSize = maxSize
+		limits.MaxSizeBytes = maxBytes
+	}
+	return limits, nil
+}
+
+func CurrentLimits() Limits {
+	return currentLimits.Load().(Limits)
+}
+
+func SetLimits(limits Limits) {
+	currentLimits.Store(limits)
+}
+
+// checkSize rejects sizes above the cap.
+func (l Limits) checkSize(sizeBytes int64) error {
+	if l.MaxSizeBytes > 0 && sizeBytes > l.MaxSizeBytes {
+		return validationErrorf("size of %d bytes exceeds the maximum volume size of %s", sizeBytes, l.MaxSize)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ConflictError is returned when another operation already holds the lock
+// for a volume.
+type ConflictError struct {
+	Volume    string
+	Operation string
+	Holder    string
+}
+
+func (e *ConflictError) Error() string {
+	if e.Holder != "" {
+		return fmt.Sprintf("cannot %s volume '%s': %s already in progress", e.Operation, e.Volume, e.Holder)
+	}
+	return fmt.Sprintf("cannot %s volume '%s': another operation is already in progress", e.Operation, e.Volume)
+}
+
+func IsConflictError(err error) bool {
+	var conflictErr *ConflictError
+	return errors.As(err, &conflictErr)
+}
+
+var volumeLocks = struct {
+	sync.Mutex
+	held map[string]string
+}{held: make(map[string]string)}
+
+func locksPath(baseDir string) string {
+	return filepath.Join(baseDir, ".locks")
+}
+
+// acquireVolumeLock takes the lock for a volume without waiting. The
+// in-process table rejects concurrent callers in this instance, and an
+// flock on <baseDir>/.locks/<name>.lock rejects callers in any other
+// instance sharing the same base directory. It returns the function that
+// releases both.
+func acquireVolumeLock(baseDir string, volumeName VolumeName, operation string) (func(), error) {
+	name := volumeName.String()
+	key := filepath.Join(filepath.Clean(baseDir), name)
+
+	volumeLocks.Lock()
+	if holder, ok := volumeLocks.held[key]; ok {
+		volumeLocks.Unlock()
+		return nil, &ConflictError{Volume: name, Operation: operation, Holder: holder}
+	}
+	volumeLocks.held[key] = operation
+	volumeLocks.Unlock()
+
+	releaseInProcess := func() {
+		volumeLocks.Lock()
+		delete(volumeLocks.held, key)
+		volumeLocks.Unlock()
+	}
+
+	if err := os.MkdirAll(locksPath(baseDir), 0755); err != nil {
+		releaseInProcess()
+		return nil, fmt.Errorf("failed to create locks directory: %v", err)
+	}
+
+	lockFile, err := os.OpenFile(filepath.Join(locksPath(baseDir), name+".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		releaseInProcess()
+		return nil, fmt.Errorf("failed to open lock file: %v", err)
+	}
+
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		holder := make([]byte, 128)
+		n, _ := lockFile.ReadAt(holder, 0)
+		lockFile.Close()
+		releaseInProcess()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, &ConflictError{Volume: name, Operation: operation, Holder: strings.TrimSpace(string(holder[:n]))}
+		}
+		return nil, fmt.Errorf("failed to lock volume: %v", err)
+	}
+
+	if err := lockFile.Truncate(0); err == nil {
+		_, _ = lockFile.WriteAt([]byte(fmt.Sprintf("%s (pid %d)\n", operation, os.Getpid())), 0)
+	}
+
+	return func() {
+		_ = lockFile.Truncate(0)
+		_ = syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+		releaseInProcess()
+	}, nil
+}
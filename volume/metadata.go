@@ -0,0 +1,193 @@
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const metadataFileName = "volume.json"
+
+// Metadata is the durable record kept next to volume.img. It is rewritten
+// atomically on every create and resize so a crash never leaves a partial
+// manifest behind.
+type Metadata struct {
+	Name          string            `json:"name"`
+	RequestedSize string            `json:"requested_size,omitempty"`
+	SizeBytes     int64             `json:"size_bytes"`
+	Optimization  OptimizationMode  `json:"optimization"`
+	Encrypted     bool              `json:"encrypted"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Owner         string            `json:"owner,omitempty"`
+	// Driver is the Docker volume driver that owns the volume; metadata
+	// written before it was recorded means DriverLocal.
+	Driver    string          `json:"driver,omitempty"`
+	Autogrow  *AutogrowPolicy `json:"autogrow,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+func metadataPath(volumePath string) string {
+	return filepath.Join(volumePath, metadataFileName)
+}
+
+func writeMetadata(volumePath string, meta *Metadata) error {
+	meta.UpdatedAt = time.Now().UTC()
+	return writeJSONFileAtomic(volumePath, metadataFileName, meta)
+}
+
+// writeJSONFileAtomic writes value to dir/fileName through a synced temp
+// file and a rename, so readers see either the old or the new content.
+func writeJSONFileAtomic(dir, fileName string, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+fileName+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(dir, fileName)); err != nil {
+		return err
+	}
+
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+func readMetadata(volumePath string) (*Metadata, error) {
+	content, err := os.ReadFile(metadataPath(volumePath))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta Metadata
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return nil, fmt.Errorf("invalid metadata file: %v", err)
+	}
+	mode, err := normalizeOptimization(string(meta.Optimization))
+	if err != nil {
+		return nil, err
+	}
+	meta.Optimization = mode
+	return &meta, nil
+}
+
+// loadMetadata returns the stored metadata for a volume, synthesizing a
+// record for volumes created before metadata was tracked.
+func loadMetadata(volumeName VolumeName, baseDir string) (*Metadata, error) {
+	name := volumeName.String()
+	volumePath := filepath.Join(baseDir, name)
+	meta, err := readMetadata(volumePath)
+	if err == nil {
+		return meta, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read metadata for '%s': %v", name, err)
+	}
+
+	imagePath := filepath.Join(volumePath, "volume.img")
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &NotFoundError{Volume: name}
+		}
+		return nil, fmt.Errorf("failed to inspect volume image: %v", err)
+	}
+
+	// Detect LUKS from the image header and fall back to the default
+	// mount options; the original optimization mode was never recorded.
+	log.Printf("No metadata recorded for %s; assuming %s optimization", name, OptimizationStandard)
+	return &Metadata{
+		Name:         name,
+		SizeBytes:    info.Size(),
+		Optimization: OptimizationStandard,
+		Encrypted:    runCommand("sudo", "cryptsetup", "isLuks", imagePath) == nil,
+		CreatedAt:    info.ModTime().UTC(),
+	}, nil
+}
+
+// DockerDriver is the Docker volume driver that owns the volume.
+func (m *Metadata) DockerDriver() string {
+	if m.Driver == "" {
+		return DriverLocal
+	}
+	return m.Driver
+}
+
+// OwnedBy reports whether driver owns the volume.
+func (m *Metadata) OwnedBy(driver string) bool {
+	return m.DockerDriver() == driver
+}
+
+// GetVolumeMetadata returns the stored metadata record for a volume.
+func GetVolumeMetadata(name VolumeName, baseDir string) (*Metadata, error) {
+	return loadMetadata(name, baseDir)
+}
+
+// MetadataUpdate lists the metadata fields to change. Nil Labels leave the
+// labels alone and an empty map clears them. Docker's own labels for the
+// volume are fixed at creation and are not touched.
+type MetadataUpdate struct {
+	Labels        map[string]string
+	Autogrow      *AutogrowPolicy
+	ClearAutogrow bool
+}
+
+// UpdateMetadata applies update to a volume's metadata under the volume lock.
+func UpdateMetadata(name VolumeName, baseDir string, update MetadataUpdate) (*Metadata, error) {
+	unlock, err := acquireVolumeLock(baseDir, name, "update")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	meta, err := loadMetadata(name, baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Labels != nil {
+		meta.Labels = update.Labels
+		if len(meta.Labels) == 0 {
+			meta.Labels = nil
+		}
+	}
+	if update.ClearAutogrow {
+		meta.Autogrow = nil
+	} else if update.Autogrow != nil {
+		if err := update.Autogrow.checkSize(meta.SizeBytes); err != nil {
+			return nil, err
+		}
+		meta.Autogrow = update.Autogrow
+	}
+
+	if err := writeMetadata(filepath.Join(baseDir, name.String()), meta); err != nil {
+		return nil, fmt.Errorf("failed to write volume metadata: %v", err)
+	}
+	return meta, nil
+}
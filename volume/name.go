@@ -0,0 +1,74 @@
+package volume
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// MaxVolumeNameLength keeps "hubfly-" plus the name within the 127
+// characters device-mapper allows for an encryption mapping.
+const MaxVolumeNameLength = 120
+
+// volumeNamePattern is Docker's rule for volume names. It rules out path
+// separators, leading dots and whitespace, so a valid name is always a
+// single path element below the base directory.
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// VolumeName is a volume name that follows Docker's naming rules and fits
+// the length limit. Decoding a VolumeName from JSON validates it.
+type VolumeName string
+
+// ParseVolumeName validates raw and returns it as a VolumeName.
+func ParseVolumeName(raw string) (VolumeName, error) {
+	if err := validateVolumeName(raw); err != nil {
+		return "", err
+	}
+	return VolumeName(raw), nil
+}
+
+func (n VolumeName) String() string {
+	return string(n)
+}
+
+func (n *VolumeName) UnmarshalJSON(data []byte) error {
+	var raw string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	name, err := ParseVolumeName(raw)
+	if err != nil {
+		return err
+	}
+	*n = name
+	return nil
+}
+
+func validateVolumeName(name string) error {
+	if name == "" {
+		return validationErrorf("volume name is required")
+	}
+	if len(name) > MaxVolumeNameLength {
+		return validationErrorf("volume name '%s' is longer than %d characters", name, MaxVolumeNameLength)
+	}
+	if !volumeNamePattern.MatchString(name) {
+		return validationErrorf("invalid volume name '%s'; use at least two characters from letters, digits, '_', '.' or '-', starting with a letter or digit", name)
+	}
+	return nil
+}
+
+// ensureMapperNameFree rejects names whose encryption mapping would clash
+// with another volume's. Mapper names are lower-cased, so volumes whose
+// names differ only in case cannot coexist.
+func ensureMapperNameFree(name, baseDir string) error {
+	names, err := ListVolumeNames(baseDir)
+	if err != nil {
+		return err
+	}
+	for _, existing := range names {
+		if existing != name && strings.EqualFold(existing, name) {
+			return validationErrorf("volume name '%s' collides with existing volume '%s'; names may not differ only in case", name, existing)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package volume
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseVolumeName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"data", true},
+		{"my-volume_1.2", true},
+		{"ab", true},
+		{strings.Repeat("a", MaxVolumeNameLength), true},
+		{"", false},
+		{"a", false},
+		{strings.Repeat("a", MaxVolumeNameLength+1), false},
+		{"../../etc", false},
+		{"..", false},
+		{"a/b", false},
+		{"a\\b", false},
+		{".hidden", false},
+		{"-flag", false},
+		{"_under", false},
+		{"with space", false},
+		{" leading", false},
+		{"trailing ", false},
+		{"tab\tname", false},
+		{"new\nline", false},
+	}
+
+	for _, tt := range tests {
+		name, err := ParseVolumeName(tt.name)
+		if tt.valid {
+			if err != nil {
+				t.Errorf("ParseVolumeName(%q) returned error: %v", tt.name, err)
+			} else if name.String() != tt.name {
+				t.Errorf("ParseVolumeName(%q) = %q", tt.name, name)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("ParseVolumeName(%q) accepted an invalid name", tt.name)
+			continue
+		}
+		if !IsValidationError(err) {
+			t.Errorf("ParseVolumeName(%q) returned %T, want *ValidationError", tt.name, err)
+		}
+		if err := validateVolumeName(tt.name); err == nil {
+			t.Errorf("validateVolumeName(%q) accepted an invalid name", tt.name)
+		}
+	}
+}
+
+func TestVolumeNameUnmarshalJSON(t *testing.T) {
+	var name VolumeName
+	if err := name.UnmarshalJSON([]byte(`"../../etc"`)); err == nil {
+		t.Fatalf("UnmarshalJSON accepted a traversal name")
+	}
+	if err := name.UnmarshalJSON([]byte(`"data"`)); err != nil || name != "data" {
+		t.Fatalf("UnmarshalJSON(data) = %q, %v", name, err)
+	}
+}
+
+func TestEnsureMapperNameFree(t *testing.T) {
+	baseDir := t.TempDir()
+	makeVolume := func(name string) {
+		t.Helper()
+		dir := filepath.Join(baseDir, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "volume.img"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	makeVolume("Data")
+	// A directory without an image is not a volume and never collides.
+	if err := os.MkdirAll(filepath.Join(baseDir, "stray"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		collide bool
+	}{
+		{"data", true},
+		{"DATA", true},
+		{"Data", false},
+		{"data2", false},
+		{"STRAY", false},
+	}
+	for _, tt := range tests {
+		err := ensureMapperNameFree(tt.name, baseDir)
+		if tt.collide {
+			if err == nil {
+				t.Errorf("ensureMapperNameFree(%q) allowed a case-only collision with Data", tt.name)
+			} else if !IsValidationError(err) {
+				t.Errorf("ensureMapperNameFree(%q) returned %T, want *ValidationError", tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ensureMapperNameFree(%q) returned error: %v", tt.name, err)
+		}
+	}
+
+	if mapperNameForVolume("Data") != mapperNameForVolume("data") {
+		t.Errorf("mapper names of Data and data differ; the collision check is no longer needed or is incomplete")
+	}
+}
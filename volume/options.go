@@ -0,0 +1,46 @@
+package volume
+
+import (
+	"fmt"
+	"log"
+)
+
+// Option configures a single call to a volume operation.
+type Option func(*operation)
+
+// WithProgress registers fn to receive a short message for every step an
+// operation starts, in addition to the service log.
+func WithProgress(fn func(step string)) Option {
+	return func(op *operation) {
+		op.progress = fn
+	}
+}
+
+// WithSnapshots lets DeleteVolume and DeleteVolumeData remove a volume
+// that still has snapshots, deleting them with it.
+func WithSnapshots() Option {
+	return func(op *operation) {
+		op.deleteSnapshots = true
+	}
+}
+
+type operation struct {
+	progress        func(step string)
+	deleteSnapshots bool
+}
+
+func newOperation(opts []Option) *operation {
+	op := &operation{}
+	for _, opt := range opts {
+		opt(op)
+	}
+	return op
+}
+
+func (op *operation) stepf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	log.Print(message)
+	if op.progress != nil {
+		op.progress(message)
+	}
+}
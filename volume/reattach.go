@@ -0,0 +1,128 @@
+package volume
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type ReattachOutcome string
+
+const (
+	ReattachMounted        ReattachOutcome = "mounted"
+	ReattachAlreadyMounted ReattachOutcome = "already_mounted"
+	ReattachFailed         ReattachOutcome = "failed"
+)
+
+type ReattachResult struct {
+	Name    string          `json:"name"`
+	Outcome ReattachOutcome `json:"outcome"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// ReattachVolumes walks baseDir and mounts every volume image that is not
+// currently mounted, reopening LUKS mappings where needed. It is meant to
+// run once at startup, before the HTTP server accepts traffic.
+func ReattachVolumes(baseDir string) ([]ReattachResult, error) {
+	names, err := ListVolumeNames(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ReattachResult
+	for _, name := range names {
+		result := ReattachResult{Name: name}
+		outcome, err := ReattachVolume(VolumeName(name), baseDir)
+		result.Outcome = outcome
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// ReattachVolume mounts a single volume image at its _data directory using
+// the optimization mode it was created with. It fails with a ConflictError
+// while another operation holds the volume, since a shrink or restore
+// unmounts the image on purpose and must not find it mounted again.
+func ReattachVolume(volumeName VolumeName, baseDir string) (ReattachOutcome, error) {
+	name := volumeName.String()
+
+	unlock, err := acquireVolumeLock(baseDir, volumeName, "mount")
+	if err != nil {
+		return ReattachFailed, err
+	}
+	defer unlock()
+
+	dataPath := filepath.Join(baseDir, name, "_data")
+	if isMountPoint(dataPath) {
+		return ReattachAlreadyMounted, nil
+	}
+
+	meta, err := loadMetadata(volumeName, baseDir)
+	if err != nil {
+		return ReattachFailed, err
+	}
+
+	if err := ensureAttached(name, filepath.Join(baseDir, name), meta, ""); err != nil {
+		return ReattachFailed, err
+	}
+	return ReattachMounted, nil
+}
+
+// ensureAttached mounts a volume's image at _data unless it already is,
+// reusing an open encryption mapping when there is one. An empty key falls
+// back to VOLUME_ENCRYPTION_KEY.
+func ensureAttached(name, volumePath string, meta *Metadata, key string) error {
+	dataPath := filepath.Join(volumePath, "_data")
+	imagePath := filepath.Join(volumePath, "volume.img")
+
+	if isMountPoint(dataPath) {
+		return nil
+	}
+
+	if err := os.MkdirAll(dataPath, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	mountSource := imagePath
+	encryptedOpened := false
+	if meta.Encrypted {
+		mapperName := mapperNameForVolume(name)
+		mountSource = mapperPath(mapperName)
+		if _, err := os.Stat(mountSource); os.IsNotExist(err) {
+			key, err := resolveEncryptionKey(VolumeConfig{EnableEncryption: true, EncryptionKey: key})
+			if err != nil {
+				return &EncryptionKeyError{Volume: name}
+			}
+			if err := openEncryptedDevice(imagePath, mapperName, key); err != nil {
+				return err
+			}
+			encryptedOpened = true
+		} else if err != nil {
+			return fmt.Errorf("failed to inspect encryption mapper: %v", err)
+		}
+	}
+
+	mountOpts := mountOptionsForMode(meta.Optimization)
+	log.Printf("Reattaching volume %s at %s with options: %s", name, dataPath, mountOpts)
+	if err := runCommand("sudo", "mount", "-o", mountOpts, mountSource, dataPath); err != nil {
+		if encryptedOpened {
+			if closeErr := closeEncryptionMapping(name); closeErr != nil {
+				log.Printf("rollback warning: failed to close encryption mapping %s: %v", name, closeErr)
+			}
+		}
+		return fmt.Errorf("mount failed: %v", err)
+	}
+
+	return nil
+}
+
+func isMountPoint(path string) bool {
+	output, err := runCommandWithOutput("findmnt", "-n", "-o", "SOURCE", "--mountpoint", path)
+	return err == nil && strings.TrimSpace(output) != ""
+}
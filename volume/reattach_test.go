@@ -0,0 +1,22 @@
+package volume
+
+import "testing"
+
+// A mount arriving while a shrink or restore holds the volume must not
+// remount the image under it.
+func TestReattachVolumeFailsWhileLocked(t *testing.T) {
+	baseDir := t.TempDir()
+	unlock, err := acquireVolumeLock(baseDir, "data", "shrink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	outcome, err := ReattachVolume("data", baseDir)
+	if !IsConflictError(err) {
+		t.Fatalf("ReattachVolume while locked = %v, want a ConflictError", err)
+	}
+	if outcome != ReattachFailed {
+		t.Errorf("outcome = %s, want %s", outcome, ReattachFailed)
+	}
+}
@@ -0,0 +1,250 @@
+package volume
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RestoreSnapshot rolls a volume back to a snapshot in place. The snapshot
+// image is staged next to the live image first, then the volume is
+// detached, the images are swapped and the volume is attached again. The
+// Docker registration is left untouched because it binds to _data. If the
+// restored image cannot be attached the original image is put back and
+// remounted.
+func RestoreSnapshot(volumeName VolumeName, baseDir, snapshotName, encryptionKey string) error {
+	name := volumeName.String()
+
+	unlock, err := acquireVolumeLock(baseDir, volumeName, "restore")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snapshot, err := GetSnapshot(volumeName, baseDir, snapshotName)
+	if err != nil {
+		return err
+	}
+
+	meta, err := loadMetadata(volumeName, baseDir)
+	if err != nil {
+		return err
+	}
+
+	key, err := resolveEncryptionKey(VolumeConfig{EnableEncryption: meta.Encrypted || snapshot.Metadata.Encrypted, EncryptionKey: encryptionKey})
+	if err != nil {
+		return err
+	}
+
+	volumePath := filepath.Join(baseDir, name)
+	dataPath := filepath.Join(volumePath, "_data")
+	imagePath := filepath.Join(volumePath, "volume.img")
+	stagedPath := imagePath + ".restore"
+	previousPath := imagePath + ".pre-restore"
+	snapshotImage := filepath.Join(snapshotPath(volumePath, snapshotName), "volume.img")
+
+	if err := copyImage(snapshotImage, stagedPath); err != nil {
+		os.Remove(stagedPath)
+		return err
+	}
+	defer os.Remove(stagedPath)
+
+	if err := detachImage(name, dataPath); err != nil {
+		if reattachErr := ensureAttached(name, volumePath, meta, key); reattachErr != nil {
+			log.Printf("rollback warning: failed to reattach %s: %v", name, reattachErr)
+		}
+		return err
+	}
+
+	log.Printf("Swapping volume image for %s with snapshot %s", name, snapshotName)
+	if err := os.Rename(imagePath, previousPath); err != nil {
+		if reattachErr := ensureAttached(name, volumePath, meta, key); reattachErr != nil {
+			log.Printf("rollback warning: failed to reattach %s: %v", name, reattachErr)
+		}
+		return fmt.Errorf("failed to move current image aside: %v", err)
+	}
+	if err := os.Rename(stagedPath, imagePath); err != nil {
+		restoreOriginalImage(name, volumePath, previousPath, meta, key)
+		return fmt.Errorf("failed to move snapshot image into place: %v", err)
+	}
+
+	if err := attachImage(name, imagePath, dataPath, snapshot.Metadata.Encrypted, key, meta.Optimization); err != nil {
+		restoreOriginalImage(name, volumePath, previousPath, meta, key)
+		return fmt.Errorf("failed to attach restored image: %v", err)
+	}
+
+	if err := os.Remove(previousPath); err != nil {
+		log.Printf("warning: failed to remove previous image %s: %v", previousPath, err)
+	}
+
+	meta.RequestedSize = snapshot.Metadata.RequestedSize
+	meta.SizeBytes = snapshot.SizeBytes
+	meta.Encrypted = snapshot.Metadata.Encrypted
+	if err := writeMetadata(volumePath, meta); err != nil {
+		return fmt.Errorf("restored snapshot but failed to write metadata: %v", err)
+	}
+
+	return nil
+}
+
+func restoreOriginalImage(name, volumePath, previousPath string, meta *Metadata, key string) {
+	if err := detachImage(name, filepath.Join(volumePath, "_data")); err != nil {
+		log.Printf("rollback warning: failed to detach %s: %v", name, err)
+	}
+	if err := os.Rename(previousPath, filepath.Join(volumePath, "volume.img")); err != nil {
+		log.Printf("rollback warning: failed to restore original image for %s: %v", name, err)
+		return
+	}
+	if err := ensureAttached(name, volumePath, meta, key); err != nil {
+		log.Printf("rollback warning: failed to reattach original image for %s: %v", name, err)
+	}
+}
+
+// CloneSnapshot provisions a new volume named targetName from a snapshot.
+// Labels, Owner, EncryptionKey, Optimization and Driver are
+// taken from config; everything else comes from the snapshot. The clone is
+// held to the same size cap and free space check as a new volume, and a
+// failure at any step is rolled back the same way CreateVolume does.
+func CloneSnapshot(sourceName VolumeName, baseDir, snapshotName string, target VolumeName, config VolumeConfig) (*Metadata, error) {
+	name, targetName := sourceName.String(), target.String()
+
+	unlockSource, err := acquireVolumeLock(baseDir, sourceName, "clone")
+	if err != nil {
+		return nil, err
+	}
+	defer unlockSource()
+
+	unlockTarget, err := acquireVolumeLock(baseDir, target, "create")
+	if err != nil {
+		return nil, err
+	}
+	defer unlockTarget()
+
+	snapshot, err := GetSnapshot(sourceName, baseDir, snapshotName)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := snapshot.Metadata.Optimization
+	if config.Optimization != "" {
+		if mode, err = normalizeOptimization(config.Optimization); err != nil {
+			return nil, err
+		}
+	}
+
+	encryptionKey, err := resolveEncryptionKey(VolumeConfig{EnableEncryption: snapshot.Metadata.Encrypted, EncryptionKey: config.EncryptionKey})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := CurrentLimits().checkSize(snapshot.SizeBytes); err != nil {
+		return nil, err
+	}
+	if err := ensureHostSpace(baseDir, snapshot.SizeBytes); err != nil {
+		return nil, err
+	}
+
+	volumePath := filepath.Join(baseDir, targetName)
+	dataPath := filepath.Join(volumePath, "_data")
+	absDataPath, err := filepath.Abs(dataPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve absolute path: %v", err)
+	}
+	imagePath := filepath.Join(volumePath, "volume.img")
+
+	if err := ensureVolumeAbsent(targetName, baseDir, imagePath, !config.registersWithDocker()); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dataPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	rollback := &provisionRollback{name: targetName, volumePath: volumePath, dataPath: dataPath}
+	success := false
+	defer func() {
+		if !success {
+			rollback.run()
+		}
+	}()
+
+	snapshotImage := filepath.Join(snapshotPath(filepath.Join(baseDir, name), snapshotName), "volume.img")
+	if err := copyImage(snapshotImage, imagePath); err != nil {
+		return nil, err
+	}
+
+	if err := attachImage(targetName, imagePath, dataPath, snapshot.Metadata.Encrypted, encryptionKey, mode); err != nil {
+		return nil, err
+	}
+	rollback.mounted = true
+	rollback.encryptedOpened = snapshot.Metadata.Encrypted
+
+	if config.registersWithDocker() {
+		log.Printf("Registering docker volume: %s", targetName)
+		if err := registerDockerVolume(targetName, absDataPath, config.Labels); err != nil {
+			return nil, err
+		}
+		rollback.dockerRegistered = true
+	}
+
+	meta := &Metadata{
+		Name:          targetName,
+		RequestedSize: snapshot.Metadata.RequestedSize,
+		SizeBytes:     snapshot.SizeBytes,
+		Optimization:  mode,
+		Encrypted:     snapshot.Metadata.Encrypted,
+		Labels:        config.Labels,
+		Owner:         config.Owner,
+		Driver:        config.driver(),
+		CreatedAt:     time.Now().UTC(),
+	}
+	if err := writeMetadata(volumePath, meta); err != nil {
+		return nil, fmt.Errorf("failed to write volume metadata: %v", err)
+	}
+
+	success = true
+	return meta, nil
+}
+
+// attachImage opens the LUKS mapping for encrypted images and mounts the
+// image at dataPath. A mapping opened here is closed again if the mount
+// fails.
+func attachImage(name, imagePath, dataPath string, encrypted bool, key string, mode OptimizationMode) error {
+	mountSource := imagePath
+	if encrypted {
+		mapperName := mapperNameForVolume(name)
+		if err := openEncryptedDevice(imagePath, mapperName, key); err != nil {
+			return err
+		}
+		mountSource = mapperPath(mapperName)
+	}
+
+	mountOpts := mountOptionsForMode(mode)
+	log.Printf("Mounting volume image at %s with options: %s", dataPath, mountOpts)
+	if err := runCommand("sudo", "mount", "-o", mountOpts, mountSource, dataPath); err != nil {
+		if encrypted {
+			if closeErr := closeEncryptionMapping(name); closeErr != nil {
+				log.Printf("rollback warning: failed to close encryption mapping %s: %v", name, closeErr)
+			}
+		}
+		return fmt.Errorf("mount failed: %v", err)
+	}
+	return nil
+}
+
+// detachImage unmounts dataPath if it is mounted and closes the volume's
+// encryption mapping if one is open.
+func detachImage(name, dataPath string) error {
+	if isMountPoint(dataPath) {
+		log.Printf("Unmounting volume at %s", dataPath)
+		if err := runCommand("sudo", "umount", dataPath); err != nil {
+			return fmt.Errorf("unmount failed: %v", err)
+		}
+	}
+	if err := closeEncryptionMapping(name); err != nil {
+		return fmt.Errorf("failed to close encryption mapping: %v", err)
+	}
+	return nil
+}
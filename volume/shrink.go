@@ -0,0 +1,224 @@
+package volume
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const fsBlockSize = 4096
+
+// ShrinkVolume reduces a volume's image to requestedSize while it is
+// offline. The volume is unmounted, a copy of the image is kept aside, the
+// filesystem is checked and shrunk, the LUKS mapping (for encrypted
+// volumes) and the image are cut down, and the volume is mounted again. If
+// any step fails the untouched copy is moved back and remounted. The host
+// must have room for that copy, or an InsufficientSpaceError is returned.
+func ShrinkVolume(volumeName VolumeName, baseDir, requestedSize, encryptionKey string, opts ...Option) (int64, int64, error) {
+	op := newOperation(opts)
+	name := volumeName.String()
+
+	requestedSize = strings.TrimSpace(requestedSize)
+	if requestedSize == "" {
+		return 0, 0, validationErrorf("requested size is required")
+	}
+
+	unlock, err := acquireVolumeLock(baseDir, volumeName, "shrink")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+
+	meta, err := loadMetadata(volumeName, baseDir)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	key, err := resolveEncryptionKey(VolumeConfig{EnableEncryption: meta.Encrypted, EncryptionKey: encryptionKey})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	volumePath := filepath.Join(baseDir, name)
+	dataPath := filepath.Join(volumePath, "_data")
+	imagePath := filepath.Join(volumePath, "volume.img")
+	backupPath := imagePath + ".pre-shrink"
+
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to inspect volume image: %v", err)
+	}
+	currentBytes := info.Size()
+	requestedBytes, err := resolveRequestedSize(requestedSize, currentBytes)
+	if err != nil {
+		return 0, 0, validationErrorf("invalid requested size: %v", err)
+	}
+	if requestedBytes >= currentBytes {
+		return 0, 0, validationErrorf("new size must be smaller than current size (%d bytes) when shrinking", currentBytes)
+	}
+
+	if isMountPoint(dataPath) {
+		_, usedBytes, err := mountedUsage(dataPath)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to read used space: %v", err)
+		}
+		if usedBytes+shrinkHeadroom(requestedBytes) > requestedBytes {
+			return 0, 0, validationErrorf("volume uses %d bytes, which does not fit in %d bytes with headroom", usedBytes, requestedBytes)
+		}
+	}
+
+	// The backup is a full copy of the current image; make sure it fits
+	// before the volume is taken offline.
+	if err := ensureHostSpace(volumePath, currentBytes); err != nil {
+		return 0, 0, err
+	}
+
+	op.stepf("Unmounting volume %s", name)
+	if err := detachImage(name, dataPath); err != nil {
+		if reattachErr := ensureAttached(name, volumePath, meta, key); reattachErr != nil {
+			log.Printf("rollback warning: failed to reattach %s: %v", name, reattachErr)
+		}
+		return 0, 0, err
+	}
+
+	op.stepf("Backing up volume image for %s", name)
+	if err := copyImage(imagePath, backupPath); err != nil {
+		os.Remove(backupPath)
+		if reattachErr := ensureAttached(name, volumePath, meta, key); reattachErr != nil {
+			log.Printf("rollback warning: failed to reattach %s: %v", name, reattachErr)
+		}
+		return 0, 0, fmt.Errorf("failed to back up volume image: %w", err)
+	}
+
+	if err := shrinkImage(name, imagePath, requestedBytes, meta.Encrypted, key, op); err != nil {
+		restoreOriginalImage(name, volumePath, backupPath, meta, key)
+		return 0, 0, err
+	}
+
+	op.stepf("Remounting volume %s", name)
+	if err := ensureAttached(name, volumePath, meta, key); err != nil {
+		restoreOriginalImage(name, volumePath, backupPath, meta, key)
+		return 0, 0, fmt.Errorf("failed to remount shrunk volume: %v", err)
+	}
+
+	if err := os.Remove(backupPath); err != nil {
+		log.Printf("warning: failed to remove backup image %s: %v", backupPath, err)
+	}
+
+	meta.RequestedSize = recordedSize(requestedSize, requestedBytes)
+	meta.SizeBytes = requestedBytes
+	if err := writeMetadata(volumePath, meta); err != nil {
+		return currentBytes, requestedBytes, fmt.Errorf("shrunk volume but failed to write metadata: %v", err)
+	}
+
+	return currentBytes, requestedBytes, nil
+}
+
+// shrinkImage shrinks the filesystem inside an unmounted image and then
+// truncates the image itself. Encrypted images are opened for the duration
+// of the filesystem work and closed again before truncation.
+func shrinkImage(name, imagePath string, requestedBytes int64, encrypted bool, key string, op *operation) error {
+	device := imagePath
+	fsBytes := requestedBytes
+	if encrypted {
+		mapperName := mapperNameForVolume(name)
+		if err := openEncryptedDevice(imagePath, mapperName, key); err != nil {
+			return err
+		}
+		defer func() {
+			if err := closeEncryptionMapping(name); err != nil {
+				log.Printf("warning: failed to close encryption mapping %s: %v", name, err)
+			}
+		}()
+		device = mapperPath(mapperName)
+
+		headerBytes, err := luksPayloadOffsetBytes(mapperName)
+		if err != nil {
+			return err
+		}
+		fsBytes -= headerBytes
+	}
+
+	fsBytes -= fsBytes % fsBlockSize
+	if fsBytes <= 0 {
+		return validationErrorf("requested size is too small for the volume layout")
+	}
+
+	op.stepf("Checking filesystem on %s", device)
+	if err := checkFilesystem(device); err != nil {
+		return err
+	}
+
+	op.stepf("Shrinking ext4 filesystem on %s to %d bytes", device, fsBytes)
+	if err := runCommand("sudo", "resize2fs", device, fmt.Sprintf("%dK", fsBytes/1024)); err != nil {
+		return fmt.Errorf("resize2fs failed: %v", err)
+	}
+
+	if encrypted {
+		mapperName := mapperNameForVolume(name)
+		op.stepf("Shrinking encrypted mapper %s", mapperName)
+		if err := runCommand("sudo", "cryptsetup", "resize", "--size", strconv.FormatInt(fsBytes/512, 10), mapperName); err != nil {
+			return fmt.Errorf("cryptsetup resize failed: %v", err)
+		}
+		if err := closeEncryptionMapping(name); err != nil {
+			return fmt.Errorf("failed to close encryption mapping: %v", err)
+		}
+	}
+
+	op.stepf("Truncating volume image %s to %d bytes", imagePath, requestedBytes)
+	if err := runCommand("sudo", "truncate", "-s", strconv.FormatInt(requestedBytes, 10), imagePath); err != nil {
+		return fmt.Errorf("truncate failed: %v", err)
+	}
+
+	return nil
+}
+
+// checkFilesystem runs a forced e2fsck. Exit status 1 means errors were
+// found and corrected, which is fine before a resize.
+func checkFilesystem(device string) error {
+	err := runCommand("sudo", "e2fsck", "-f", "-y", device)
+	if err == nil {
+		return nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return nil
+	}
+	return fmt.Errorf("e2fsck failed: %v", err)
+}
+
+func luksPayloadOffsetBytes(mapperName string) (int64, error) {
+	output, err := runCommandWithOutput("sudo", "cryptsetup", "status", mapperName)
+	if err != nil {
+		return 0, fmt.Errorf("cryptsetup status failed: %v", err)
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "offset:" {
+			sectors, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid LUKS offset value: %v", err)
+			}
+			return sectors * 512, nil
+		}
+	}
+	return 0, fmt.Errorf("LUKS offset not found in cryptsetup status output")
+}
+
+// shrinkHeadroom is the space kept free on top of the used bytes so the
+// shrunk filesystem still has room for metadata and the reserved blocks.
+func shrinkHeadroom(requestedBytes int64) int64 {
+	headroom := int64(64 * 1024 * 1024)
+	tenPercent := requestedBytes / 10
+	if tenPercent > headroom {
+		headroom = tenPercent
+	}
+	return headroom
+}
@@ -0,0 +1,228 @@
+package volume
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const snapshotMetadataFileName = "snapshot.json"
+
+var snapshotNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Snapshot is a point-in-time copy of a volume image. Metadata holds the
+// source volume's record as it was when the snapshot was taken.
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Volume    string    `json:"volume"`
+	SizeBytes int64     `json:"size_bytes"`
+	CreatedAt time.Time `json:"created_at"`
+	Metadata  Metadata  `json:"metadata"`
+}
+
+func snapshotsPath(volumePath string) string {
+	return filepath.Join(volumePath, "snapshots")
+}
+
+func snapshotPath(volumePath, snapshotName string) string {
+	return filepath.Join(snapshotsPath(volumePath), snapshotName)
+}
+
+func validateSnapshotName(snapshotName string) error {
+	if !snapshotNamePattern.MatchString(snapshotName) {
+		return validationErrorf("invalid snapshot name '%s'; use letters, digits, '_', '.' or '-'", snapshotName)
+	}
+	return nil
+}
+
+// CreateSnapshot freezes the volume's filesystem and copies its image into
+// the volume's snapshots directory. The copy is a reflink when the host
+// filesystem supports it and a sparse copy otherwise. An empty snapshotName
+// is replaced with a UTC timestamp.
+func CreateSnapshot(volumeName VolumeName, baseDir, snapshotName string, opts ...Option) (*Snapshot, error) {
+	op := newOperation(opts)
+	name := volumeName.String()
+
+	snapshotName = strings.TrimSpace(snapshotName)
+	if snapshotName == "" {
+		snapshotName = time.Now().UTC().Format("20060102T150405Z")
+	}
+	if err := validateSnapshotName(snapshotName); err != nil {
+		return nil, err
+	}
+
+	unlock, err := acquireVolumeLock(baseDir, volumeName, "snapshot")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	meta, err := loadMetadata(volumeName, baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	volumePath := filepath.Join(baseDir, name)
+	dataPath := filepath.Join(volumePath, "_data")
+	imagePath := filepath.Join(volumePath, "volume.img")
+	snapshotDir := snapshotPath(volumePath, snapshotName)
+	snapshotImage := filepath.Join(snapshotDir, "volume.img")
+
+	if err := os.MkdirAll(snapshotsPath(volumePath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshots directory: %v", err)
+	}
+	if err := os.Mkdir(snapshotDir, 0755); err != nil {
+		if os.IsExist(err) {
+			return nil, &ExistsError{Volume: name, Snapshot: snapshotName}
+		}
+		return nil, fmt.Errorf("failed to create snapshot directory: %v", err)
+	}
+
+	success := false
+	defer func() {
+		if success {
+			return
+		}
+		if err := os.RemoveAll(snapshotDir); err != nil {
+			log.Printf("rollback warning: failed to remove snapshot path %s: %v", snapshotDir, err)
+		}
+	}()
+
+	op.stepf("Copying volume image of %s to snapshot %s", name, snapshotName)
+	if err := copyImageFrozen(dataPath, imagePath, snapshotImage); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(snapshotImage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect snapshot image: %v", err)
+	}
+
+	snapshot := &Snapshot{
+		Name:      snapshotName,
+		Volume:    name,
+		SizeBytes: info.Size(),
+		CreatedAt: time.Now().UTC(),
+		Metadata:  *meta,
+	}
+	if err := writeJSONFileAtomic(snapshotDir, snapshotMetadataFileName, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot metadata: %v", err)
+	}
+
+	success = true
+	return snapshot, nil
+}
+
+// copyImageFrozen copies a volume image while its filesystem is frozen so
+// the copy is crash-consistent. Unmounted volumes are copied as-is.
+func copyImageFrozen(dataPath, imagePath, destination string) error {
+	if isMountPoint(dataPath) {
+		log.Printf("Freezing filesystem at %s", dataPath)
+		if err := runCommand("sudo", "fsfreeze", "-f", dataPath); err != nil {
+			return fmt.Errorf("fsfreeze failed: %v", err)
+		}
+		defer func() {
+			log.Printf("Thawing filesystem at %s", dataPath)
+			if err := runCommand("sudo", "fsfreeze", "-u", dataPath); err != nil {
+				log.Printf("warning: failed to thaw %s: %v", dataPath, err)
+			}
+		}()
+	}
+
+	return copyImage(imagePath, destination)
+}
+
+func copyImage(source, destination string) error {
+	log.Printf("Copying volume image %s to %s", source, destination)
+	if err := runCommand("sudo", "cp", "--reflink=auto", "--sparse=always", source, destination); err != nil {
+		return asHostSpaceError(fmt.Errorf("image copy failed: %w", err), destination)
+	}
+	return nil
+}
+
+func ListSnapshots(name VolumeName, baseDir string) ([]*Snapshot, error) {
+	if _, err := loadMetadata(name, baseDir); err != nil {
+		return nil, err
+	}
+
+	dir := snapshotsPath(filepath.Join(baseDir, name.String()))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Snapshot{}, nil
+		}
+		return nil, fmt.Errorf("failed to read snapshots directory: %v", err)
+	}
+
+	snapshots := []*Snapshot{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		snapshot, err := readSnapshot(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			log.Printf("failed to read snapshot %s of %s: %v", entry.Name(), name, err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+	return snapshots, nil
+}
+
+func GetSnapshot(volumeName VolumeName, baseDir, snapshotName string) (*Snapshot, error) {
+	name := volumeName.String()
+	if err := validateSnapshotName(snapshotName); err != nil {
+		return nil, err
+	}
+
+	snapshot, err := readSnapshot(snapshotPath(filepath.Join(baseDir, name), snapshotName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &NotFoundError{Volume: name, Snapshot: snapshotName}
+		}
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func DeleteSnapshot(name VolumeName, baseDir, snapshotName string) error {
+	unlock, err := acquireVolumeLock(baseDir, name, "delete snapshot of")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := GetSnapshot(name, baseDir, snapshotName); err != nil {
+		return err
+	}
+
+	snapshotDir := snapshotPath(filepath.Join(baseDir, name.String()), snapshotName)
+	log.Printf("Removing snapshot directory: %s", snapshotDir)
+	if err := os.RemoveAll(snapshotDir); err != nil {
+		return fmt.Errorf("failed to remove snapshot directory: %v", err)
+	}
+	return nil
+}
+
+func readSnapshot(snapshotDir string) (*Snapshot, error) {
+	content, err := os.ReadFile(filepath.Join(snapshotDir, snapshotMetadataFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot metadata: %v", err)
+	}
+	return &snapshot, nil
+}
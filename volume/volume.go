@@ -6,11 +6,13 @@ import (
 	"log"
 	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
+
+	"hubfly-storage/command"
 )
 
 type OptimizationMode string
@@ -27,15 +29,38 @@ type VolumeConfig struct {
 	EncryptionKey    string
 	Optimization     string
 	Labels           map[string]string
+	Owner            string
+	Autogrow         *AutogrowPolicy
+	// Driver is the Docker volume driver that owns the volume. Empty means
+	// DriverLocal, for which the volume is registered through the docker
+	// CLI; any other driver drives the lifecycle itself through the volume
+	// plugin API and is recorded in the metadata.
+	Driver string
+}
+
+// DriverLocal is the Docker driver volumes created through the HTTP API are
+// registered with.
+const DriverLocal = "local"
+
+func (c VolumeConfig) registersWithDocker() bool {
+	return c.Driver == "" || c.Driver == DriverLocal
+}
+
+func (c VolumeConfig) driver() string {
+	if c.Driver == "" {
+		return DriverLocal
+	}
+	return c.Driver
 }
 
 type VolumeStats struct {
-	Name      string `json:"name"`
-	Size      string `json:"size"`
-	Used      string `json:"used"`
-	Available string `json:"available"`
-	Usage     string `json:"usage"`
-	MountPath string `json:"mount_path"`
+	Name      string    `json:"name"`
+	Size      string    `json:"size"`
+	Used      string    `json:"used"`
+	Available string    `json:"available"`
+	Usage     string    `json:"usage"`
+	MountPath string    `json:"mount_path"`
+	Metadata  *Metadata `json:"metadata,omitempty"`
 }
 
 var sizePattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([a-zA-Z]*)$`)
@@ -57,35 +82,63 @@ func IsValidationError(err error) bool {
 	return errors.As(err, &validationErr)
 }
 
-func runCommand(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	output, err := cmd.CombinedOutput()
-	log.Printf("Command: %s %v\nOutput: %s", name, args, output)
+// ConfigFromDriverOpts builds a VolumeConfig from Docker-style driver
+// options, applying the service defaults for anything left unset.
+func ConfigFromDriverOpts(opts map[string]string, labels map[string]string) (VolumeConfig, error) {
+	limits := CurrentLimits()
+	size := opts["size"]
+	if size == "" {
+		size = limits.DefaultSize
+	}
+
+	enableEncryption, err := parseOptionalBool(opts["encryption"])
 	if err != nil {
-		return fmt.Errorf("%v: %s", err, output)
+		return VolumeConfig{}, validationErrorf("invalid encryption value: %v", err)
 	}
-	return nil
+
+	optimization := opts["optimization"]
+	if optimization == "" {
+		optimization = string(limits.DefaultOptimization)
+	}
+
+	var autogrow *AutogrowPolicy
+	if opts["autogrow_threshold"] != "" || opts["autogrow_step"] != "" || opts["autogrow_max"] != "" {
+		autogrow, err = ParseAutogrowPolicy(opts["autogrow_threshold"], opts["autogrow_step"], opts["autogrow_max"])
+		if err != nil {
+			return VolumeConfig{}, err
+		}
+	}
+
+	return VolumeConfig{
+		Size:             size,
+		EnableEncryption: enableEncryption,
+		EncryptionKey:    opts["encryption_key"],
+		Optimization:     optimization,
+		Labels:           labels,
+		Owner:            opts["owner"],
+		Autogrow:         autogrow,
+	}, nil
 }
 
-func runCommandWithInput(input, name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Stdin = strings.NewReader(input)
-	output, err := cmd.CombinedOutput()
-	log.Printf("Command: %s %v\nOutput: %s", name, args, output)
+func parseOptionalBool(raw string) (bool, error) {
+	if strings.TrimSpace(raw) == "" {
+		return false, nil
+	}
+
+	parsed, err := strconv.ParseBool(strings.TrimSpace(raw))
 	if err != nil {
-		return fmt.Errorf("%v: %s", err, output)
+		return false, fmt.Errorf("expected one of true/false/1/0")
 	}
-	return nil
+
+	return parsed, nil
+}
+
+func runCommand(name string, args ...string) error {
+	return command.New(name, args...).Run()
 }
 
 func runCommandWithOutput(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	output, err := cmd.CombinedOutput()
-	log.Printf("Command: %s %v\nOutput: %s", name, args, output)
-	if err != nil {
-		return string(output), fmt.Errorf("%v: %s", err, output)
-	}
-	return string(output), nil
+	return command.New(name, args...).Output()
 }
 
 func volumeExists(name string) (bool, error) {
@@ -97,16 +150,22 @@ func volumeExists(name string) (bool, error) {
 	return exists, nil
 }
 
-func CreateVolume(name, baseDir string, config VolumeConfig) (string, error) {
-	exists, err := volumeExists(name)
+func CreateVolume(volumeName VolumeName, baseDir string, config VolumeConfig, opts ...Option) (string, error) {
+	op := newOperation(opts)
+	name := volumeName.String()
+
+	unlock, err := acquireVolumeLock(baseDir, volumeName, "create")
 	if err != nil {
-		return "", fmt.Errorf("failed to check for existing volume: %v", err)
-	}
-	if exists {
-		return "", fmt.Errorf("volume '%s' already exists", name)
+		return "", err
 	}
+	defer unlock()
 
-	normalizedMode, err := normalizeOptimization(config.Optimization)
+	limits := CurrentLimits()
+	optimization := config.Optimization
+	if strings.TrimSpace(optimization) == "" {
+		optimization = string(limits.DefaultOptimization)
+	}
+	normalizedMode, err := normalizeOptimization(optimization)
 	if err != nil {
 		return "", err
 	}
@@ -116,6 +175,24 @@ func CreateVolume(name, baseDir string, config VolumeConfig) (string, error) {
 		return "", err
 	}
 
+	size := config.Size
+	if size == "" {
+		size = limits.DefaultSize
+	}
+	sizeBytes, err := parseSizeToBytes(size)
+	if err != nil {
+		return "", validationErrorf("invalid size '%s': %v", size, err)
+	}
+	if err := limits.checkSize(sizeBytes); err != nil {
+		return "", err
+	}
+	if err := config.Autogrow.checkSize(sizeBytes); err != nil {
+		return "", err
+	}
+	if err := ensureHostSpace(baseDir, sizeBytes); err != nil {
+		return "", err
+	}
+
 	volumePath := filepath.Join(baseDir, name)
 	dataPath := filepath.Join(volumePath, "_data")
 	absDataPath, err := filepath.Abs(dataPath)
@@ -124,69 +201,49 @@ func CreateVolume(name, baseDir string, config VolumeConfig) (string, error) {
 	}
 	imagePath := filepath.Join(volumePath, "volume.img")
 
-	size := config.Size
-	if size == "" {
-		size = "1G"
+	if err := ensureVolumeAbsent(name, baseDir, imagePath, !config.registersWithDocker()); err != nil {
+		return "", err
 	}
 
 	if err := os.MkdirAll(dataPath, 0755); err != nil {
 		return "", fmt.Errorf("failed to create directory: %v", err)
 	}
 
-	mounted := false
-	encryptedOpened := false
-	dockerRegistered := false
+	rollback := &provisionRollback{name: name, volumePath: volumePath, dataPath: dataPath}
 	success := false
 	defer func() {
-		if success {
-			return
-		}
-		if dockerRegistered {
-			if err := runCommand("docker", "volume", "rm", name); err != nil {
-				log.Printf("rollback warning: failed to remove docker volume %s: %v", name, err)
-			}
-		}
-		if mounted {
-			if err := runCommand("sudo", "umount", dataPath); err != nil {
-				log.Printf("rollback warning: failed to unmount %s: %v", dataPath, err)
-			}
-		}
-		if encryptedOpened {
-			if err := closeEncryptionMapping(name); err != nil {
-				log.Printf("rollback warning: failed to close encryption mapping %s: %v", name, err)
-			}
-		}
-		if err := os.RemoveAll(volumePath); err != nil {
-			log.Printf("rollback warning: failed to remove volume path %s: %v", volumePath, err)
+		if !success {
+			rollback.run()
 		}
 	}()
 
-	log.Printf("Allocating %s image file at %s", size, imagePath)
-	if err := runCommand("sudo", "fallocate", "-l", size, imagePath); err != nil {
-		return "", fmt.Errorf("fallocate failed: %v", err)
+	op.stepf("Allocating %s (%d bytes) image file at %s", size, sizeBytes, imagePath)
+	if err := runCommand("sudo", "fallocate", "-l", strconv.FormatInt(sizeBytes, 10), imagePath); err != nil {
+		return "", asHostSpaceError(fmt.Errorf("fallocate failed: %w", err), imagePath)
 	}
 
 	mountSource := imagePath
 	if config.EnableEncryption {
 		mapperName := mapperNameForVolume(name)
+		op.stepf("Setting up encryption for %s", name)
 		if err := setupEncryptedDevice(imagePath, mapperName, encryptionKey); err != nil {
 			return "", err
 		}
 		mountSource = mapperPath(mapperName)
-		encryptedOpened = true
+		rollback.encryptedOpened = true
 	}
 
-	log.Printf("Formatting %s as ext4", mountSource)
+	op.stepf("Formatting %s as ext4", mountSource)
 	if err := runCommand("sudo", "mkfs.ext4", mountSource); err != nil {
 		return "", fmt.Errorf("mkfs.ext4 failed: %v", err)
 	}
 
 	mountOpts := mountOptionsForMode(normalizedMode)
-	log.Printf("Mounting volume image at %s with options: %s", dataPath, mountOpts)
+	op.stepf("Mounting volume image at %s with options: %s", dataPath, mountOpts)
 	if err := runCommand("sudo", "mount", "-o", mountOpts, mountSource, dataPath); err != nil {
 		return "", fmt.Errorf("mount failed: %v", err)
 	}
-	mounted = true
+	rollback.mounted = true
 
 	lostAndFoundPath := filepath.Join(dataPath, "lost+found")
 	log.Printf("Removing lost+found directory: %s", lostAndFoundPath)
@@ -194,7 +251,7 @@ func CreateVolume(name, baseDir string, config VolumeConfig) (string, error) {
 		log.Printf("warning: failed to remove lost+found: %v", err)
 	}
 
-	log.Printf("Setting permissions for data directory: %s to 777", absDataPath)
+	op.stepf("Setting permissions for data directory: %s to 777", absDataPath)
 	if err := runCommand("sudo", "chmod", "-R", "777", absDataPath); err != nil {
 		return "", fmt.Errorf("chmod failed: %v", err)
 	}
@@ -206,7 +263,96 @@ func CreateVolume(name, baseDir string, config VolumeConfig) (string, error) {
 		}
 	}
 
-	log.Printf("Registering docker volume: %s", name)
+	if config.registersWithDocker() {
+		op.stepf("Registering docker volume: %s", name)
+		if err := registerDockerVolume(name, absDataPath, config.Labels); err != nil {
+			return "", err
+		}
+		rollback.dockerRegistered = true
+	}
+
+	imageInfo, err := os.Stat(imagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect volume image: %v", err)
+	}
+	meta := &Metadata{
+		Name:          name,
+		RequestedSize: size,
+		SizeBytes:     imageInfo.Size(),
+		Optimization:  normalizedMode,
+		Encrypted:     config.EnableEncryption,
+		Labels:        config.Labels,
+		Owner:         config.Owner,
+		Driver:        config.driver(),
+		Autogrow:      config.Autogrow,
+		CreatedAt:     time.Now().UTC(),
+	}
+	if err := writeMetadata(volumePath, meta); err != nil {
+		return "", fmt.Errorf("failed to write volume metadata: %v", err)
+	}
+
+	success = true
+	return name, nil
+}
+
+// ensureVolumeAbsent fails when a volume image or, unless skipDocker is
+// set, a Docker volume with the same name already exists.
+func ensureVolumeAbsent(name, baseDir, imagePath string, skipDocker bool) error {
+	if err := ensureMapperNameFree(name, baseDir); err != nil {
+		return err
+	}
+	if !skipDocker {
+		exists, err := volumeExists(name)
+		if err != nil {
+			return fmt.Errorf("failed to check for existing volume: %v", err)
+		}
+		if exists {
+			return &ExistsError{Volume: name}
+		}
+	}
+
+	if _, err := os.Stat(imagePath); err == nil {
+		return &ExistsError{Volume: name}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to inspect volume image: %v", err)
+	}
+	return nil
+}
+
+// provisionRollback undoes the steps of a volume provisioning that did not
+// complete, in reverse order, and removes the volume directory.
+type provisionRollback struct {
+	name       string
+	volumePath string
+	dataPath   string
+
+	mounted          bool
+	encryptedOpened  bool
+	dockerRegistered bool
+}
+
+func (r *provisionRollback) run() {
+	if r.dockerRegistered {
+		if err := runCommand("docker", "volume", "rm", r.name); err != nil {
+			log.Printf("rollback warning: failed to remove docker volume %s: %v", r.name, err)
+		}
+	}
+	if r.mounted {
+		if err := runCommand("sudo", "umount", r.dataPath); err != nil {
+			log.Printf("rollback warning: failed to unmount %s: %v", r.dataPath, err)
+		}
+	}
+	if r.encryptedOpened {
+		if err := closeEncryptionMapping(r.name); err != nil {
+			log.Printf("rollback warning: failed to close encryption mapping %s: %v", r.name, err)
+		}
+	}
+	if err := os.RemoveAll(r.volumePath); err != nil {
+		log.Printf("rollback warning: failed to remove volume path %s: %v", r.volumePath, err)
+	}
+}
+
+func registerDockerVolume(name, absDataPath string, labels map[string]string) error {
 	dockerArgs := []string{
 		"docker", "volume", "create",
 		"--name", name,
@@ -215,24 +361,64 @@ func CreateVolume(name, baseDir string, config VolumeConfig) (string, error) {
 		"--opt", "o=bind",
 	}
 
-	for key, value := range config.Labels {
+	for key, value := range labels {
 		dockerArgs = append(dockerArgs, "--label", fmt.Sprintf("%s=%s", key, value))
 	}
 
 	if err := runCommand(dockerArgs[0], dockerArgs[1:]...); err != nil {
-		return "", fmt.Errorf("docker volume create failed: %v", err)
+		return fmt.Errorf("docker volume create failed: %v", err)
 	}
-	dockerRegistered = true
+	return nil
+}
 
-	success = true
-	return name, nil
+// DeleteVolume unmounts a volume, removes its Docker registration and
+// deletes its directory. A volume with snapshots is only deleted, together
+// with them, when WithSnapshots is passed.
+func DeleteVolume(name VolumeName, baseDir string, opts ...Option) error {
+	return deleteVolume(name, baseDir, true, newOperation(opts))
+}
+
+// DeleteVolumeData unmounts and removes a volume without touching Docker's
+// volume registry, for callers where Docker owns the registration.
+func DeleteVolumeData(name VolumeName, baseDir string, opts ...Option) error {
+	return deleteVolume(name, baseDir, false, newOperation(opts))
 }
 
-func DeleteVolume(name, baseDir string) error {
+func deleteVolume(volumeName VolumeName, baseDir string, removeDockerVolume bool, op *operation) error {
+	name := volumeName.String()
+
+	unlock, err := acquireVolumeLock(baseDir, volumeName, "delete")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	volumePath := filepath.Join(baseDir, name)
 	dataPath := filepath.Join(volumePath, "_data")
 
-	log.Printf("Unmounting volume at %s", dataPath)
+	// A registration left behind without its directory is still removed.
+	if _, err := os.Stat(volumePath); os.IsNotExist(err) {
+		registered := false
+		if removeDockerVolume {
+			registered, _ = volumeExists(name)
+		}
+		if !registered {
+			return &NotFoundError{Volume: name}
+		}
+	}
+
+	// Snapshots live under the volume directory and go with it.
+	if !op.deleteSnapshots {
+		entries, err := os.ReadDir(snapshotsPath(volumePath))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read snapshots directory: %v", err)
+		}
+		if len(entries) > 0 {
+			return &HasSnapshotsError{Volume: name, Snapshots: len(entries)}
+		}
+	}
+
+	op.stepf("Unmounting volume at %s", dataPath)
 	if err := runCommand("sudo", "umount", dataPath); err != nil {
 		log.Printf("unmount failed (might be acceptable if not mounted): %v", err)
 	}
@@ -241,12 +427,18 @@ func DeleteVolume(name, baseDir string) error {
 		log.Printf("warning: failed to close encryption mapping for %s: %v", name, err)
 	}
 
-	log.Printf("Removing docker volume: %s", name)
-	if err := runCommand("docker", "volume", "rm", name); err != nil {
-		return fmt.Errorf("docker volume rm failed: %v", err)
+	if removeDockerVolume {
+		op.stepf("Removing docker volume: %s", name)
+		if err := runCommand("docker", "volume", "rm", name); err != nil {
+			return fmt.Errorf("docker volume rm failed: %v", err)
+		}
+	}
+
+	if err := os.Remove(metadataPath(volumePath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove volume metadata: %v", err)
 	}
 
-	log.Printf("Removing volume directory: %s", volumePath)
+	op.stepf("Removing volume directory: %s", volumePath)
 	if err := os.RemoveAll(volumePath); err != nil {
 		return fmt.Errorf("failed to remove volume directory: %v", err)
 	}
@@ -254,21 +446,20 @@ func DeleteVolume(name, baseDir string) error {
 	return nil
 }
 
-func ResizeVolume(name, baseDir, requestedSize string) (int64, int64, error) {
-	name = strings.TrimSpace(name)
-	if name == "" {
-		return 0, 0, validationErrorf("volume name is required")
-	}
+func ResizeVolume(volumeName VolumeName, baseDir, requestedSize string, opts ...Option) (int64, int64, error) {
+	op := newOperation(opts)
+	name := volumeName.String()
 
 	requestedSize = strings.TrimSpace(requestedSize)
 	if requestedSize == "" {
 		return 0, 0, validationErrorf("requested size is required")
 	}
 
-	requestedBytes, err := parseSizeToBytes(requestedSize)
+	unlock, err := acquireVolumeLock(baseDir, volumeName, "resize")
 	if err != nil {
-		return 0, 0, validationErrorf("invalid requested size: %v", err)
+		return 0, 0, err
 	}
+	defer unlock()
 
 	volumePath := filepath.Join(baseDir, name)
 	dataPath := filepath.Join(volumePath, "_data")
@@ -277,19 +468,30 @@ func ResizeVolume(name, baseDir, requestedSize string) (int64, int64, error) {
 	info, err := os.Stat(imagePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return 0, 0, validationErrorf("volume image not found for '%s'", name)
+			return 0, 0, &NotFoundError{Volume: name}
 		}
 		return 0, 0, fmt.Errorf("failed to inspect volume image: %v", err)
 	}
 
 	currentBytes := info.Size()
+	requestedBytes, err := resolveRequestedSize(requestedSize, currentBytes)
+	if err != nil {
+		return 0, 0, validationErrorf("invalid requested size: %v", err)
+	}
 	if requestedBytes <= currentBytes {
-		return 0, 0, validationErrorf("new size must be greater than current size (%d bytes); scaling down is not supported", currentBytes)
+		return 0, 0, validationErrorf("new size must be greater than current size (%d bytes); set DriverOpts.shrink=true to scale down", currentBytes)
+	}
+	if err := CurrentLimits().checkSize(requestedBytes); err != nil {
+		return 0, 0, err
+	}
+
+	if err := ensureHostSpace(volumePath, requestedBytes-currentBytes); err != nil {
+		return 0, 0, err
 	}
 
-	log.Printf("Resizing volume image for %s from %d to %d bytes", name, currentBytes, requestedBytes)
+	op.stepf("Resizing volume image for %s from %d to %d bytes", name, currentBytes, requestedBytes)
 	if err := runCommand("sudo", "fallocate", "-l", strconv.FormatInt(requestedBytes, 10), imagePath); err != nil {
-		return 0, 0, fmt.Errorf("fallocate failed: %v", err)
+		return 0, 0, asHostSpaceError(fmt.Errorf("fallocate failed: %w", err), imagePath)
 	}
 
 	mountSource, mountErr := mountedSourceForTarget(dataPath)
@@ -306,7 +508,7 @@ func ResizeVolume(name, baseDir, requestedSize string) (int64, int64, error) {
 	mapperName := mapperNameForVolume(name)
 	mapperDevice := mapperPath(mapperName)
 	if _, err := os.Stat(mapperDevice); err == nil {
-		log.Printf("Resizing encrypted mapper %s", mapperName)
+		op.stepf("Resizing encrypted mapper %s", mapperName)
 		if err := runCommand("sudo", "cryptsetup", "resize", mapperName); err != nil {
 			return currentBytes, requestedBytes, fmt.Errorf("cryptsetup resize failed: %v", err)
 		}
@@ -319,7 +521,7 @@ func ResizeVolume(name, baseDir, requestedSize string) (int64, int64, error) {
 		return currentBytes, requestedBytes, fmt.Errorf("failed to detect resize target: %v", err)
 	}
 
-	log.Printf("Growing ext4 filesystem for %s using target %s", name, resizeTarget)
+	op.stepf("Growing ext4 filesystem for %s using target %s", name, resizeTarget)
 	if err := runCommand("sudo", "resize2fs", resizeTarget); err != nil {
 		return currentBytes, requestedBytes, fmt.Errorf("resize2fs failed after image growth; rerun resize once mount state is healthy: %v", err)
 	}
@@ -334,17 +536,31 @@ func ResizeVolume(name, baseDir, requestedSize string) (int64, int64, error) {
 		}
 	}
 
+	meta, err := loadMetadata(volumeName, baseDir)
+	if err != nil {
+		return currentBytes, requestedBytes, fmt.Errorf("resized volume but failed to load metadata: %v", err)
+	}
+	meta.RequestedSize = recordedSize(requestedSize, requestedBytes)
+	meta.SizeBytes = requestedBytes
+	if err := writeMetadata(volumePath, meta); err != nil {
+		return currentBytes, requestedBytes, fmt.Errorf("resized volume but failed to write metadata: %v", err)
+	}
+
 	return currentBytes, requestedBytes, nil
 }
 
 func setupEncryptedDevice(imagePath, mapperName, key string) error {
 	log.Printf("Creating LUKS2 encrypted device for %s", imagePath)
-	if err := runCommandWithInput(key+"\n", "sudo", "cryptsetup", "-q", "luksFormat", "--type", "luks2", imagePath, "-"); err != nil {
+	if err := command.New("sudo", "cryptsetup", "-q", "luksFormat", "--type", "luks2", imagePath, "-").SecretStdin(key + "\n").Run(); err != nil {
 		return fmt.Errorf("cryptsetup luksFormat failed: %v", err)
 	}
 
+	return openEncryptedDevice(imagePath, mapperName, key)
+}
+
+func openEncryptedDevice(imagePath, mapperName, key string) error {
 	log.Printf("Opening encrypted device mapping %s", mapperName)
-	if err := runCommandWithInput(key+"\n", "sudo", "cryptsetup", "open", imagePath, mapperName, "-"); err != nil {
+	if err := command.New("sudo", "cryptsetup", "open", imagePath, mapperName, "-").SecretStdin(key + "\n").Run(); err != nil {
 		return fmt.Errorf("cryptsetup open failed: %v", err)
 	}
 
@@ -391,7 +607,7 @@ func resolveEncryptionKey(config VolumeConfig) (string, error) {
 		return envKey, nil
 	}
 
-	return "", fmt.Errorf("encryption requested but no key provided; set DriverOpts.encryption_key or VOLUME_ENCRYPTION_KEY")
+	return "", &EncryptionKeyError{}
 }
 
 func normalizeOptimization(raw string) (OptimizationMode, error) {
@@ -410,7 +626,7 @@ func normalizeOptimization(raw string) (OptimizationMode, error) {
 	case OptimizationStandard, OptimizationHighPerformance, OptimizationBalanced:
 		return mode, nil
 	default:
-		return "", fmt.Errorf("unsupported optimization mode '%s'; expected one of: standard, high_performance, balanced", raw)
+		return "", validationErrorf("unsupported optimization mode '%s'; expected one of: standard, high_performance, balanced", raw)
 	}
 }
 
@@ -471,23 +687,34 @@ func refreshLoopDevice(mountSource string) error {
 }
 
 func mountedSizeBytes(target string) (int64, error) {
+	sizeBytes, _, err := mountedUsage(target)
+	return sizeBytes, err
+}
+
+// mountedUsage returns the filesystem size and used bytes reported by df
+// for the filesystem mounted at target.
+func mountedUsage(target string) (int64, int64, error) {
 	output, err := runCommandWithOutput("df", "-B1", target)
 	if err != nil {
-		return 0, fmt.Errorf("df -B1 failed: %v", err)
+		return 0, 0, fmt.Errorf("df -B1 failed: %v", err)
 	}
 	lines := strings.Split(output, "\n")
 	if len(lines) < 2 {
-		return 0, fmt.Errorf("invalid df output")
+		return 0, 0, fmt.Errorf("invalid df output")
 	}
 	fields := strings.Fields(lines[1])
-	if len(fields) < 2 {
-		return 0, fmt.Errorf("invalid df output fields")
+	if len(fields) < 3 {
+		return 0, 0, fmt.Errorf("invalid df output fields")
 	}
 	sizeBytes, err := strconv.ParseInt(fields[1], 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("invalid df size value: %v", err)
+		return 0, 0, fmt.Errorf("invalid df size value: %v", err)
+	}
+	usedBytes, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid df used value: %v", err)
 	}
-	return sizeBytes, nil
+	return sizeBytes, usedBytes, nil
 }
 
 func sizeWithinTolerance(actualBytes, requestedBytes int64) bool {
@@ -502,6 +729,63 @@ func sizeWithinTolerance(actualBytes, requestedBytes int64) bool {
 	return actualBytes+slack >= requestedBytes
 }
 
+// resolveRequestedSize turns a resize request into an absolute size. Besides
+// absolute sizes it accepts deltas such as "+5G" or "-512M" and percentages
+// of the current size such as "+25%" or "-10%".
+func resolveRequestedSize(raw string, currentBytes int64) (int64, error) {
+	raw = strings.TrimSpace(raw)
+	if !isRelativeSize(raw) {
+		return parseSizeToBytes(raw)
+	}
+
+	sign := int64(1)
+	if raw[0] == '-' {
+		sign = -1
+	}
+	amount := strings.TrimSpace(raw[1:])
+
+	var delta int64
+	if strings.HasSuffix(amount, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(amount, "%")), 64)
+		if err != nil || percent <= 0 {
+			return 0, fmt.Errorf("expected a positive percentage like +25%%")
+		}
+		deltaFloat := math.Ceil(float64(currentBytes) * percent / 100)
+		if deltaFloat > float64(int64(^uint64(0)>>1)-currentBytes) {
+			return 0, fmt.Errorf("size is too large")
+		}
+		delta = int64(deltaFloat)
+	} else {
+		parsed, err := parseSizeToBytes(amount)
+		if err != nil {
+			return 0, err
+		}
+		delta = parsed
+	}
+
+	if sign > 0 && delta > int64(^uint64(0)>>1)-currentBytes {
+		return 0, fmt.Errorf("size is too large")
+	}
+	target := currentBytes + sign*delta
+	if target <= 0 {
+		return 0, fmt.Errorf("size must be greater than zero")
+	}
+	return target, nil
+}
+
+func isRelativeSize(raw string) bool {
+	return strings.HasPrefix(raw, "+") || strings.HasPrefix(raw, "-")
+}
+
+// recordedSize is the requested size stored in metadata: the caller's
+// input for absolute sizes and the resolved byte count for relative ones.
+func recordedSize(raw string, resolvedBytes int64) string {
+	if isRelativeSize(strings.TrimSpace(raw)) {
+		return strconv.FormatInt(resolvedBytes, 10)
+	}
+	return raw
+}
+
 func parseSizeToBytes(raw string) (int64, error) {
 	raw = strings.TrimSpace(raw)
 	matches := sizePattern.FindStringSubmatch(raw)
@@ -531,30 +815,32 @@ func parseSizeToBytes(raw string) (int64, error) {
 	return int64(math.Ceil(bytesFloat)), nil
 }
 
+// unitMultiplier follows fallocate: a bare K, M, G, T or P and the
+// Ki/KiB forms are binary, and only KB, MB, GB, TB and PB are decimal.
 func unitMultiplier(rawUnit string) (int64, error) {
 	unit := strings.ToLower(strings.TrimSpace(rawUnit))
 	switch unit {
 	case "", "b":
 		return 1, nil
-	case "k", "kb":
+	case "kb":
 		return 1000, nil
-	case "m", "mb":
+	case "mb":
 		return 1000 * 1000, nil
-	case "g", "gb":
+	case "gb":
 		return 1000 * 1000 * 1000, nil
-	case "t", "tb":
+	case "tb":
 		return 1000 * 1000 * 1000 * 1000, nil
-	case "p", "pb":
+	case "pb":
 		return 1000 * 1000 * 1000 * 1000 * 1000, nil
-	case "ki", "kib":
+	case "k", "ki", "kib":
 		return 1024, nil
-	case "mi", "mib":
+	case "m", "mi", "mib":
 		return 1024 * 1024, nil
-	case "gi", "gib":
+	case "g", "gi", "gib":
 		return 1024 * 1024 * 1024, nil
-	case "ti", "tib":
+	case "t", "ti", "tib":
 		return 1024 * 1024 * 1024 * 1024, nil
-	case "pi", "pib":
+	case "p", "pi", "pib":
 		return 1024 * 1024 * 1024 * 1024 * 1024, nil
 	default:
 		return 0, fmt.Errorf("unsupported size unit '%s'", rawUnit)
@@ -562,8 +848,14 @@ func unitMultiplier(rawUnit string) (int64, error) {
 }
 
 func GetVolumeStats(name, baseDir string) (*VolumeStats, error) {
+	if err := validateVolumeName(name); err != nil {
+		return nil, err
+	}
 	volumePath := filepath.Join(baseDir, name)
 	dataPath := filepath.Join(volumePath, "_data")
+	if _, err := os.Stat(filepath.Join(volumePath, "volume.img")); os.IsNotExist(err) {
+		return nil, &NotFoundError{Volume: name}
+	}
 
 	output, err := runCommandWithOutput("df", "-h", dataPath)
 	if err != nil {
@@ -589,6 +881,12 @@ func GetVolumeStats(name, baseDir string) (*VolumeStats, error) {
 		MountPath: fields[5],
 	}
 
+	if meta, err := loadMetadata(VolumeName(name), baseDir); err == nil {
+		stats.Metadata = meta
+	} else {
+		log.Printf("warning: failed to load metadata for %s: %v", name, err)
+	}
+
 	return stats, nil
 }
 
@@ -604,22 +902,47 @@ func formatSize(size string) string {
 	return size
 }
 
-func GetAllVolumes(baseDir string) ([]*VolumeStats, error) {
-	files, err := os.ReadDir(baseDir)
+// ListVolumeNames returns the names of all directories under baseDir that
+// contain a volume image.
+func ListVolumeNames(baseDir string) ([]string, error) {
+	entries, err := os.ReadDir(baseDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read base directory: %v", err)
 	}
 
-	var volumes []*VolumeStats
-	for _, file := range files {
-		if file.IsDir() {
-			stats, err := GetVolumeStats(file.Name(), baseDir)
-			if err != nil {
-				log.Printf("failed to get stats for %s: %v", file.Name(), err)
-				continue
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		imagePath := filepath.Join(baseDir, entry.Name(), "volume.img")
+		if _, err := os.Stat(imagePath); err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("failed to inspect volume image %s: %v", imagePath, err)
 			}
-			volumes = append(volumes, stats)
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+
+	return names, nil
+}
+
+func GetAllVolumes(baseDir string) ([]*VolumeStats, error) {
+	names, err := ListVolumeNames(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := []*VolumeStats{}
+	for _, name := range names {
+		stats, err := GetVolumeStats(name, baseDir)
+		if err != nil {
+			log.Printf("failed to get stats for %s: %v", name, err)
+			continue
 		}
+		volumes = append(volumes, stats)
 	}
 
 	return volumes, nil
@@ -0,0 +1,235 @@
+package volume
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// An unparseable size is rejected even without a size cap, before anything
+// is written under the base directory.
+func TestCreateVolumeRejectsInvalidSize(t *testing.T) {
+	limits := CurrentLimits()
+	defer SetLimits(limits)
+	uncapped := limits
+	uncapped.MaxSizeBytes = 0
+	SetLimits(uncapped)
+
+	baseDir := t.TempDir()
+	for _, size := range []string{"lots", "10X", "-1G", "0"} {
+		_, err := CreateVolume("data", baseDir, VolumeConfig{Size: size, Driver: DriverLocal})
+		if !IsValidationError(err) {
+			t.Errorf("CreateVolume with size %q = %v, want a validation error", size, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "data")); !os.IsNotExist(err) {
+		t.Errorf("volume directory created for an invalid size: %v", err)
+	}
+}
+
+// Sizes are passed to fallocate as byte counts, so a bare unit must keep
+// the binary meaning fallocate gives it.
+func TestParseSizeToBytesMatchesFallocate(t *testing.T) {
+	tests := []struct {
+		size string
+		want int64
+	}{
+		{"10G", 10737418240},
+		{"10GiB", 10737418240},
+		{"10GB", 10000000000},
+		{"512M", 536870912},
+		{"1.5K", 1536},
+		{"4096", 4096},
+	}
+	for _, tt := range tests {
+		got, err := parseSizeToBytes(tt.size)
+		if err != nil || got != tt.want {
+			t.Errorf("parseSizeToBytes(%q) = %d, %v; want %d", tt.size, got, err, tt.want)
+		}
+	}
+}
+
+// A clone allocates a full copy of the snapshot image, so it is held to the
+// size cap before anything is written for the target.
+func TestCloneSnapshotRejectsSnapshotAboveSizeCap(t *testing.T) {
+	limits := CurrentLimits()
+	defer SetLimits(limits)
+	capped := limits
+	capped.MaxSize, capped.MaxSizeBytes = "1G", 1<<30
+	SetLimits(capped)
+
+	baseDir := t.TempDir()
+	snapshotDir := snapshotPath(filepath.Join(baseDir, "data"), "big")
+	if err := os.MkdirAll(snapshotDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := `{"name":"big","volume":"data","size_bytes":2147483648,"metadata":{"name":"data","optimization":"standard"}}`
+	if err := os.WriteFile(filepath.Join(snapshotDir, snapshotMetadataFileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := CloneSnapshot("data", baseDir, "big", "copy", VolumeConfig{Driver: DriverLocal})
+	if !IsValidationError(err) {
+		t.Fatalf("CloneSnapshot = %v, want a validation error", err)
+	}
+	if _, err := os.Stat(filepath.Join(baseDir, "copy")); !os.IsNotExist(err) {
+		t.Errorf("target directory created for an oversized clone: %v", err)
+	}
+}
+
+// The pre-shrink backup is a full copy of the image, so a host without room
+// for it fails before the volume is taken offline.
+func TestShrinkVolumeChecksSpaceForBackup(t *testing.T) {
+	baseDir := t.TempDir()
+	volumePath := filepath.Join(baseDir, "data")
+	if err := os.MkdirAll(filepath.Join(volumePath, "_data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMetadata(volumePath, &Metadata{Name: "data", Optimization: OptimizationStandard}); err != nil {
+		t.Fatal(err)
+	}
+	image, err := os.Create(filepath.Join(volumePath, "volume.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Sparse, so it takes no space, but a copy would not fit.
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(baseDir, &stat); err != nil {
+		t.Fatal(err)
+	}
+	if err := image.Truncate(int64(stat.Bavail)*int64(stat.Bsize) + 1<<30); err != nil {
+		t.Fatal(err)
+	}
+	image.Close()
+
+	_, _, err = ShrinkVolume("data", baseDir, "1G", "")
+	if !IsInsufficientSpaceError(err) {
+		t.Fatalf("ShrinkVolume = %v, want an InsufficientSpaceError", err)
+	}
+	if _, err := os.Stat(filepath.Join(volumePath, "volume.img.pre-shrink")); !os.IsNotExist(err) {
+		t.Errorf("backup written despite the missing space: %v", err)
+	}
+}
+
+func TestResolveRequestedSize(t *testing.T) {
+	const current = 10 << 30 // 10G
+	tests := []struct {
+		raw     string
+		current int64
+		want    int64
+		wantErr bool
+	}{
+		{raw: "20G", current: current, want: 20 << 30},
+		{raw: "+5G", current: current, want: 15 << 30},
+		{raw: "-2G", current: current, want: 8 << 30},
+		{raw: " + 512M ", current: current, want: current + 512<<20},
+		{raw: "+150%", current: current, want: 25 << 30},
+		{raw: "-10%", current: current, want: 9 << 30},
+		{raw: "+0.5%", current: 1000, want: 1005},
+		// A percentage is always relative to the current size.
+		{raw: "150%", current: current, wantErr: true},
+		{raw: "-10G", current: current, wantErr: true},
+		{raw: "-11G", current: current, wantErr: true},
+		{raw: "-100%", current: current, wantErr: true},
+		{raw: "+0%", current: current, wantErr: true},
+		{raw: "+lots", current: current, wantErr: true},
+		{raw: "+1G", current: math.MaxInt64 - 1, wantErr: true},
+		{raw: "+1000000000000%", current: current, wantErr: true},
+		{raw: "+100000000P", current: current, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveRequestedSize(tt.raw, tt.current)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("resolveRequestedSize(%q, %d) = %d, want an error", tt.raw, tt.current, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("resolveRequestedSize(%q, %d) = %d, %v; want %d", tt.raw, tt.current, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseAutogrowPolicy(t *testing.T) {
+	tests := []struct {
+		name                     string
+		threshold, step, maxSize string
+		wantErr                  bool
+		wantThreshold            float64
+		wantMaxBytes             int64
+	}{
+		{name: "valid", threshold: "80", step: "5G", maxSize: "50G", wantThreshold: 80, wantMaxBytes: 50 << 30},
+		{name: "percent sign and plus", threshold: "80%", step: "+25%", maxSize: "50G", wantThreshold: 80, wantMaxBytes: 50 << 30},
+		{name: "lowest threshold", threshold: "1", step: "1G", maxSize: "2G", wantThreshold: 1, wantMaxBytes: 2 << 30},
+		{name: "highest threshold", threshold: "99", step: "1G", maxSize: "2G", wantThreshold: 99, wantMaxBytes: 2 << 30},
+		{name: "threshold zero", threshold: "0", step: "1G", maxSize: "2G", wantErr: true},
+		{name: "threshold below 1", threshold: "0.5", step: "1G", maxSize: "2G", wantErr: true},
+		{name: "threshold 100", threshold: "100", step: "1G", maxSize: "2G", wantErr: true},
+		{name: "negative threshold", threshold: "-10", step: "1G", maxSize: "2G", wantErr: true},
+		{name: "threshold not a number", threshold: "high", step: "1G", maxSize: "2G", wantErr: true},
+		{name: "missing threshold", step: "1G", maxSize: "2G", wantErr: true},
+		{name: "missing step", threshold: "80", maxSize: "2G", wantErr: true},
+		{name: "blank step", threshold: "80", step: "  ", maxSize: "2G", wantErr: true},
+		{name: "missing max", threshold: "80", step: "1G", wantErr: true},
+		{name: "zero step", threshold: "80", step: "0", maxSize: "2G", wantErr: true},
+		{name: "invalid max", threshold: "80", step: "1G", maxSize: "lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParseAutogrowPolicy(tt.threshold, tt.step, tt.maxSize)
+			if tt.wantErr {
+				if !IsValidationError(err) {
+					t.Errorf("ParseAutogrowPolicy = %+v, %v; want a validation error", policy, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy.ThresholdPercent != tt.wantThreshold || policy.MaxBytes != tt.wantMaxBytes {
+				t.Errorf("policy = %+v, want threshold %v and max %d bytes", policy, tt.wantThreshold, tt.wantMaxBytes)
+			}
+		})
+	}
+}
+
+// A policy whose maximum is below the current size could never grow the
+// volume, so it is rejected and the stored policy is left alone.
+func TestSetAutogrowPolicyRejectsMaxBelowCurrentSize(t *testing.T) {
+	baseDir := t.TempDir()
+	volumePath := filepath.Join(baseDir, "data")
+	if err := os.MkdirAll(volumePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMetadata(volumePath, &Metadata{Name: "data", SizeBytes: 10 << 30, Optimization: OptimizationStandard}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		maxSize string
+		wantErr bool
+	}{
+		{"5G", true},
+		{"10G", false},
+		{"20G", false},
+	}
+	for _, tt := range tests {
+		policy, err := ParseAutogrowPolicy("80", "1G", tt.maxSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta, err := SetAutogrowPolicy("data", baseDir, policy)
+		if tt.wantErr {
+			if !IsValidationError(err) {
+				t.Errorf("SetAutogrowPolicy with max %s = %v, want a validation error", tt.maxSize, err)
+			}
+			continue
+		}
+		if err != nil || meta.Autogrow.MaxSize != tt.maxSize {
+			t.Errorf("SetAutogrowPolicy with max %s = %+v, %v", tt.maxSize, meta, err)
+		}
+	}
+}